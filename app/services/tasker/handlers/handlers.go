@@ -16,8 +16,15 @@ import (
 
 	"github.com/jmoiron/sqlx"
 	"github.com/jnkroeker/khyme/app/services/tasker/handlers/debug/check"
+	"github.com/jnkroeker/khyme/app/services/tasker/handlers/v1/dlq"
+	"github.com/jnkroeker/khyme/app/services/tasker/handlers/v1/pipeline"
+	"github.com/jnkroeker/khyme/app/services/tasker/handlers/v1/queue"
+	"github.com/jnkroeker/khyme/app/services/tasker/handlers/v1/schedule"
 	"github.com/jnkroeker/khyme/app/services/tasker/handlers/v1/task"
+	"github.com/jnkroeker/khyme/app/services/tasker/handlers/v1/template"
 	"github.com/jnkroeker/khyme/app/services/tasker/handlers/v1/test"
+	queueCore "github.com/jnkroeker/khyme/business/core/queue"
+	scheduleCore "github.com/jnkroeker/khyme/business/core/schedule"
 	taskCore "github.com/jnkroeker/khyme/business/core/task"
 	"github.com/jnkroeker/khyme/business/web/mid"
 	"github.com/jnkroeker/khyme/foundation/web"
@@ -61,9 +68,13 @@ func DebugMux(build string, log *zap.SugaredLogger, db *sqlx.DB) http.Handler {
 
 // contains all the mandatory systems required by handlers
 type APIMuxConfig struct {
-	Shutdown chan os.Signal
-	Log      *zap.SugaredLogger
-	DB       *sqlx.DB
+	Shutdown  chan os.Signal
+	Log       *zap.SugaredLogger
+	DB        *sqlx.DB
+	Queue     queueCore.Config
+	Task      taskCore.Config
+	Templater taskCore.Templater
+	Lister    scheduleCore.Lister
 }
 
 // construct a new App (foundational) that embeds a mux
@@ -89,13 +100,76 @@ func APIMux(cfg APIMuxConfig) *web.App {
 	// to accept our custom Handler func type (from foundation/web)
 	app.Handle(http.MethodGet, "v1", "/test", test_handlers.Test)
 
+	task_core := taskCore.NewCore(cfg.Log, cfg.DB, cfg.Templater, cfg.Task)
+
 	task_handlers := task.Handlers{
-		Task: taskCore.NewCore(cfg.Log, cfg.DB),
+		Task: task_core,
 	}
 
-	app.Handle(http.MethodGet, version, "/tasks/:page/:rows", task_handlers.Query)
+	app.Handle(http.MethodGet, version, "/tasks", task_handlers.Query)
+	app.Handle(http.MethodGet, version, "/tasks/:id", task_handlers.QueryByID)
 	app.Handle(http.MethodPost, version, "/tasks", task_handlers.Create)
 	app.Handle(http.MethodDelete, version, "/tasks/:id", task_handlers.Delete)
+	app.Handle(http.MethodPut, version, "/tasks/:id/status", task_handlers.UpdateStatus)
+	app.Handle(http.MethodPatch, version, "/tasks/:id/priority", task_handlers.UpdatePriority)
+	app.Handle(http.MethodPost, version, "/tasks/:id/run", task_handlers.RunNow)
+	app.Handle(http.MethodPost, version, "/tasks/:id/cancel", task_handlers.Cancel)
+	app.Handle(http.MethodPost, version, "/tasks/:id/restore", task_handlers.Restore)
+	app.Handle(http.MethodGet, version, "/trash/:page/:rows", task_handlers.QueryTrash)
+	app.Handle(http.MethodGet, version, "/tasks/:id/graph", task_handlers.QueryGraph)
+	app.Handle(http.MethodGet, version, "/tasks/:id/events", task_handlers.QueryEvents)
+	app.Handle(http.MethodGet, version, "/tasks/:id/progress", task_handlers.QueryProgress)
+	app.Handle(http.MethodGet, version, "/tasks/:id/results", task_handlers.QueryResults)
+	app.Handle(http.MethodPost, version, "/tasks/:id/results", task_handlers.AddResults)
+	app.Handle(http.MethodGet, version, "/groups/:id", task_handlers.QueryGroup)
+	app.Handle(http.MethodPost, version, "/dags", task_handlers.CreateDAG)
+
+	template_handlers := template.Handlers{
+		Task: task_core,
+	}
+
+	app.Handle(http.MethodGet, version, "/templates", template_handlers.Query)
+	app.Handle(http.MethodPost, version, "/templates/dry-run", template_handlers.DryRun)
+
+	pipeline_handlers := pipeline.Handlers{
+		Task: task_core,
+	}
+
+	app.Handle(http.MethodGet, version, "/pipelines", pipeline_handlers.Query)
+	app.Handle(http.MethodPost, version, "/pipelines/:name/runs", pipeline_handlers.Start)
+	app.Handle(http.MethodGet, version, "/pipelines/:name/runs/:page/:rows", pipeline_handlers.QueryRuns)
+	app.Handle(http.MethodGet, version, "/pipelines/runs/:id", pipeline_handlers.QueryRun)
+
+	queue_core := queueCore.NewCore(cfg.Log, cfg.DB, cfg.Queue)
+
+	queue_handlers := queue.Handlers{
+		Queue: queue_core,
+	}
+
+	app.Handle(http.MethodPost, version, "/queue/claim", queue_handlers.Claim)
+	app.Handle(http.MethodPost, version, "/tasks/:id/heartbeat", queue_handlers.Heartbeat)
+	app.Handle(http.MethodPost, version, "/tasks/:id/progress", queue_handlers.Progress)
+	app.Handle(http.MethodPost, version, "/tasks/:id/fail", queue_handlers.Fail)
+
+	dlq_handlers := dlq.Handlers{
+		Queue: queue_core,
+	}
+
+	app.Handle(http.MethodGet, version, "/dlq/:page/:rows", dlq_handlers.Query)
+	app.Handle(http.MethodPost, version, "/dlq/:id/requeue", dlq_handlers.Requeue)
+	app.Handle(http.MethodDelete, version, "/dlq/:id", dlq_handlers.Delete)
+
+	schedule_core := scheduleCore.NewCore(cfg.Log, cfg.DB, task_core, cfg.Lister)
+
+	schedule_handlers := schedule.Handlers{
+		Schedule: schedule_core,
+	}
+
+	app.Handle(http.MethodGet, version, "/schedules/:page/:rows", schedule_handlers.Query)
+	app.Handle(http.MethodGet, version, "/schedules/:id", schedule_handlers.QueryByID)
+	app.Handle(http.MethodPost, version, "/schedules", schedule_handlers.Create)
+	app.Handle(http.MethodPut, version, "/schedules/:id", schedule_handlers.Update)
+	app.Handle(http.MethodDelete, version, "/schedules/:id", schedule_handlers.Delete)
 
 	return app
 }
@@ -0,0 +1,76 @@
+package dlq
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	queueCore "github.com/jnkroeker/khyme/business/core/queue"
+	"github.com/jnkroeker/khyme/business/sys/database"
+	"github.com/jnkroeker/khyme/business/sys/validate"
+	"github.com/jnkroeker/khyme/foundation/web"
+)
+
+type Handlers struct {
+	Queue queueCore.Core
+}
+
+func (h Handlers) Query(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	page := web.Param(r, "page")
+	pageNumber, err := strconv.Atoi(page)
+	if err != nil {
+		return validate.NewRequestError(fmt.Errorf("invalid page format [%s]", page), http.StatusBadRequest)
+	}
+	rows := web.Param(r, "rows")
+	rowsPerPage, err := strconv.Atoi(rows)
+	if err != nil {
+		return validate.NewRequestError(fmt.Errorf("invalid rows format [%s]", rows), http.StatusBadRequest)
+	}
+
+	dls, err := h.Queue.QueryDeadLetters(ctx, pageNumber, rowsPerPage)
+	if err != nil {
+		return fmt.Errorf("unable to query for dead letters: %w", err)
+	}
+
+	return web.Respond(ctx, w, dls, http.StatusOK)
+}
+
+func (h Handlers) Requeue(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	id := web.Param(r, "id")
+	if err := h.Queue.Requeue(ctx, id, v.Now); err != nil {
+		switch validate.Cause(err) {
+		case validate.ErrInvalidID:
+			return validate.NewRequestError(err, http.StatusBadRequest)
+		case database.ErrNotFound:
+			return validate.NewRequestError(err, http.StatusNotFound)
+		case database.ErrDBDuplicatedEntry, queueCore.ErrNotFailed:
+			return validate.NewRequestError(err, http.StatusConflict)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+func (h Handlers) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := web.Param(r, "id")
+	if err := h.Queue.DeleteDeadLetter(ctx, id); err != nil {
+		switch validate.Cause(err) {
+		case validate.ErrInvalidID:
+			return validate.NewRequestError(err, http.StatusBadRequest)
+		case database.ErrNotFound:
+			return validate.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
@@ -0,0 +1,101 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	taskCore "github.com/jnkroeker/khyme/business/core/task"
+	pipelineStore "github.com/jnkroeker/khyme/business/data/store/pipeline"
+	"github.com/jnkroeker/khyme/business/sys/database"
+	"github.com/jnkroeker/khyme/business/sys/validate"
+	"github.com/jnkroeker/khyme/foundation/web"
+)
+
+type Handlers struct {
+	Task taskCore.Core
+}
+
+// Query returns the pipelines declared in the templates file
+func (h Handlers) Query(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	pipelines := h.Task.Pipelines()
+	if pipelines == nil {
+		pipelines = []taskCore.Pipeline{}
+	}
+
+	return web.Respond(ctx, w, pipelines, http.StatusOK)
+}
+
+// Start runs a resource through the named pipeline
+func (h Handlers) Start(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var nr pipelineStore.NewRun
+	if err := web.Decode(r, &nr); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	name := web.Param(r, "name")
+	run, err := h.Task.StartPipeline(ctx, name, nr, v.Now)
+	if err != nil {
+		switch validate.Cause(err) {
+		case taskCore.ErrInvalidResource:
+			return validate.NewRequestError(err, http.StatusBadRequest)
+		case taskCore.ErrUnknownPipeline:
+			return validate.NewRequestError(err, http.StatusNotFound)
+		case taskCore.ErrUnknownTemplate:
+			return validate.NewRequestError(err, http.StatusUnprocessableEntity)
+		default:
+			return fmt.Errorf("pipeline[%s]: input[%s]: %w", name, nr.InputResource, err)
+		}
+	}
+
+	return web.Respond(ctx, w, run, http.StatusCreated)
+}
+
+func (h Handlers) QueryRuns(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	page := web.Param(r, "page")
+	pageNumber, err := strconv.Atoi(page)
+	if err != nil || pageNumber < 1 {
+		return validate.NewRequestError(fmt.Errorf("invalid page format [%s]", page), http.StatusBadRequest)
+	}
+	rows := web.Param(r, "rows")
+	rowsPerPage, err := strconv.Atoi(rows)
+	if err != nil || rowsPerPage < 1 || rowsPerPage > taskCore.MaxPageSize {
+		return validate.NewRequestError(fmt.Errorf("invalid rows format [%s]", rows), http.StatusBadRequest)
+	}
+
+	name := web.Param(r, "name")
+	runs, err := h.Task.QueryRuns(ctx, name, pageNumber, rowsPerPage)
+	if err != nil {
+		switch validate.Cause(err) {
+		case taskCore.ErrUnknownPipeline:
+			return validate.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("unable to query for runs of pipeline[%s]: %w", name, err)
+		}
+	}
+
+	return web.Respond(ctx, w, runs, http.StatusOK)
+}
+
+func (h Handlers) QueryRun(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := web.Param(r, "id")
+	run, err := h.Task.QueryRun(ctx, id)
+	if err != nil {
+		switch validate.Cause(err) {
+		case validate.ErrInvalidID:
+			return validate.NewRequestError(err, http.StatusBadRequest)
+		case database.ErrNotFound:
+			return validate.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, run, http.StatusOK)
+}
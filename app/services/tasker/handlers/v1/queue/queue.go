@@ -0,0 +1,137 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	queueCore "github.com/jnkroeker/khyme/business/core/queue"
+	"github.com/jnkroeker/khyme/business/data/store/queue"
+	"github.com/jnkroeker/khyme/business/data/store/task"
+	"github.com/jnkroeker/khyme/business/sys/validate"
+	"github.com/jnkroeker/khyme/foundation/web"
+)
+
+type Handlers struct {
+	Queue queueCore.Core
+}
+
+// Claim hands the calling worker the next batch of pending tasks
+func (h Handlers) Claim(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var cl queue.Claim
+	if err := web.Decode(r, &cl); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	tasks, err := h.Queue.Claim(ctx, cl, v.Now)
+	if err != nil {
+		switch validate.Cause(err) {
+		case queueCore.ErrWorkerRequired:
+			return validate.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return fmt.Errorf("worker[%s]: %w", cl.WorkerID, err)
+		}
+	}
+
+	resp := struct {
+		Queue             string        `json:"queue"`
+		HeartbeatInterval time.Duration `json:"heartbeat_interval"`
+		Tasks             []task.Task   `json:"tasks"`
+	}{
+		Queue:             h.Queue.Name(),
+		HeartbeatInterval: h.Queue.HeartbeatInterval(),
+		Tasks:             tasks,
+	}
+
+	return web.Respond(ctx, w, resp, http.StatusOK)
+}
+
+// Heartbeat extends the calling worker's lease on a task
+func (h Handlers) Heartbeat(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var hb queue.Heartbeat
+	if err := web.Decode(r, &hb); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	id := web.Param(r, "id")
+	lease, err := h.Queue.Heartbeat(ctx, id, hb, v.Now)
+	if err != nil {
+		switch validate.Cause(err) {
+		case validate.ErrInvalidID, queueCore.ErrWorkerRequired:
+			return validate.NewRequestError(err, http.StatusBadRequest)
+		case queueCore.ErrLeaseLost:
+			return validate.NewRequestError(err, http.StatusConflict)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, lease, http.StatusOK)
+}
+
+// Fail records a failed run reported by the worker holding the task
+func (h Handlers) Fail(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var f queue.Failure
+	if err := web.Decode(r, &f); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	id := web.Param(r, "id")
+	res, err := h.Queue.Fail(ctx, id, f, v.Now)
+	if err != nil {
+		switch validate.Cause(err) {
+		case validate.ErrInvalidID, queueCore.ErrWorkerRequired:
+			return validate.NewRequestError(err, http.StatusBadRequest)
+		case queueCore.ErrLeaseLost:
+			return validate.NewRequestError(err, http.StatusConflict)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, res, http.StatusOK)
+}
+
+// Progress records how far the worker holding the task has got with it
+func (h Handlers) Progress(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var p queue.Progress
+	if err := web.Decode(r, &p); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	id := web.Param(r, "id")
+	res, err := h.Queue.Progress(ctx, id, p, v.Now)
+	if err != nil {
+		switch validate.Cause(err) {
+		case validate.ErrInvalidID, queueCore.ErrWorkerRequired:
+			return validate.NewRequestError(err, http.StatusBadRequest)
+		case queueCore.ErrLeaseLost:
+			return validate.NewRequestError(err, http.StatusConflict)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, res, http.StatusOK)
+}
@@ -0,0 +1,124 @@
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	scheduleCore "github.com/jnkroeker/khyme/business/core/schedule"
+	taskCore "github.com/jnkroeker/khyme/business/core/task"
+	scheduleStore "github.com/jnkroeker/khyme/business/data/store/schedule"
+	"github.com/jnkroeker/khyme/business/sys/database"
+	"github.com/jnkroeker/khyme/business/sys/validate"
+	"github.com/jnkroeker/khyme/foundation/cron"
+	"github.com/jnkroeker/khyme/foundation/web"
+)
+
+type Handlers struct {
+	Schedule scheduleCore.Core
+}
+
+func (h Handlers) Query(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	page := web.Param(r, "page")
+	pageNumber, err := strconv.Atoi(page)
+	if err != nil || pageNumber < 1 {
+		return validate.NewRequestError(fmt.Errorf("invalid page format [%s]", page), http.StatusBadRequest)
+	}
+	rows := web.Param(r, "rows")
+	rowsPerPage, err := strconv.Atoi(rows)
+	if err != nil || rowsPerPage < 1 || rowsPerPage > taskCore.MaxPageSize {
+		return validate.NewRequestError(fmt.Errorf("invalid rows format [%s]", rows), http.StatusBadRequest)
+	}
+
+	schs, err := h.Schedule.Query(ctx, pageNumber, rowsPerPage)
+	if err != nil {
+		return fmt.Errorf("unable to query for schedules: %w", err)
+	}
+
+	return web.Respond(ctx, w, schs, http.StatusOK)
+}
+
+func (h Handlers) QueryByID(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := web.Param(r, "id")
+	sch, err := h.Schedule.QueryByID(ctx, id)
+	if err != nil {
+		switch validate.Cause(err) {
+		case validate.ErrInvalidID:
+			return validate.NewRequestError(err, http.StatusBadRequest)
+		case database.ErrNotFound:
+			return validate.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, sch, http.StatusOK)
+}
+
+func (h Handlers) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var ns scheduleStore.NewSchedule
+	if err := web.Decode(r, &ns); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	sch, err := h.Schedule.Create(ctx, ns, v.Now)
+	if err != nil {
+		switch validate.Cause(err) {
+		case cron.ErrInvalidExpression, scheduleCore.ErrNeverFires, scheduleCore.ErrNoLister, taskCore.ErrInvalidResource:
+			return validate.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return fmt.Errorf("schedule[%s]: %w", ns.Name, err)
+		}
+	}
+
+	return web.Respond(ctx, w, sch, http.StatusCreated)
+}
+
+func (h Handlers) Update(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var us scheduleStore.UpdateSchedule
+	if err := web.Decode(r, &us); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	id := web.Param(r, "id")
+	sch, err := h.Schedule.Update(ctx, id, us, v.Now)
+	if err != nil {
+		switch validate.Cause(err) {
+		case validate.ErrInvalidID, cron.ErrInvalidExpression, scheduleCore.ErrNeverFires, scheduleCore.ErrNoLister, taskCore.ErrInvalidResource:
+			return validate.NewRequestError(err, http.StatusBadRequest)
+		case database.ErrNotFound:
+			return validate.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, sch, http.StatusOK)
+}
+
+func (h Handlers) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := web.Param(r, "id")
+	if err := h.Schedule.Delete(ctx, id); err != nil {
+		switch validate.Cause(err) {
+		case validate.ErrInvalidID:
+			return validate.NewRequestError(err, http.StatusBadRequest)
+		case database.ErrNotFound:
+			return validate.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
@@ -0,0 +1,101 @@
+package task
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	taskStore "github.com/jnkroeker/khyme/business/data/store/task"
+)
+
+// parseFilter reads the conditions of a task listing from the query string.
+//
+//	status=pending,running  template=Mp4  hooks=mp4  image=<exec image>
+//	version=<build>  label=key:value (repeatable)  input_prefix=s3://bucket/dir/
+//	created_after=<RFC3339>  created_before=<RFC3339>  scheduled=true|false
+func parseFilter(values url.Values) (taskStore.QueryFilter, error) {
+	filter := taskStore.QueryFilter{
+		Template:       values.Get("template"),
+		Hooks:          values.Get("hooks"),
+		ExecutionImage: values.Get("image"),
+		Version:        values.Get("version"),
+		InputPrefix:    values.Get("input_prefix"),
+	}
+
+	if v := values.Get("status"); v != "" {
+		for _, s := range strings.Split(v, ",") {
+			status, err := taskStore.ParseStatus(strings.TrimSpace(s))
+			if err != nil {
+				return taskStore.QueryFilter{}, fmt.Errorf("invalid status [%s]", s)
+			}
+			filter.Status = append(filter.Status, status)
+		}
+	}
+
+	for _, v := range values["label"] {
+		kv := strings.SplitN(v, ":", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return taskStore.QueryFilter{}, fmt.Errorf("invalid label format [%s], want key:value", v)
+		}
+		if filter.Labels == nil {
+			filter.Labels = make(taskStore.Labels)
+		}
+		filter.Labels[kv[0]] = kv[1]
+	}
+
+	if v := values.Get("scheduled"); v != "" {
+		scheduled, err := strconv.ParseBool(v)
+		if err != nil {
+			return taskStore.QueryFilter{}, fmt.Errorf("invalid scheduled format [%s]", v)
+		}
+		filter.Scheduled = &scheduled
+	}
+
+	for name, dest := range map[string]**time.Time{
+		"created_after":  &filter.CreatedAfter,
+		"created_before": &filter.CreatedBefore,
+	} {
+		v := values.Get(name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return taskStore.QueryFilter{}, fmt.Errorf("invalid %s format [%s]", name, v)
+		}
+		*dest = &t
+	}
+
+	return filter, nil
+}
+
+// parseLimit reads the page size from the query string. Zero means the default.
+func parseLimit(values url.Values) (int, error) {
+	v := values.Get("limit")
+	if v == "" {
+		return 0, nil
+	}
+
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 0 {
+		return 0, fmt.Errorf("invalid limit format [%s]", v)
+	}
+
+	return limit, nil
+}
+
+// nextLink builds the Link header pointing at the page after the current one
+func nextLink(r *http.Request, cursor string) string {
+	values := r.URL.Query()
+	values.Set("cursor", cursor)
+
+	u := url.URL{
+		Path:     r.URL.Path,
+		RawQuery: values.Encode(),
+	}
+
+	return fmt.Sprintf("<%s>; rel=\"next\"", u.String())
+}
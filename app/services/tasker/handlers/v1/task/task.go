@@ -7,6 +7,7 @@ import (
 	"strconv"
 
 	taskCore "github.com/jnkroeker/khyme/business/core/task"
+	taskStore "github.com/jnkroeker/khyme/business/data/store/task"
 	"github.com/jnkroeker/khyme/business/sys/database"
 	"github.com/jnkroeker/khyme/business/sys/validate"
 	"github.com/jnkroeker/khyme/foundation/web"
@@ -16,24 +17,63 @@ type Handlers struct {
 	Task taskCore.Core
 }
 
+// Query returns a page of tasks. The query string narrows and orders the
+// listing; the next page is linked by cursor in the body and the Link header.
 func (h Handlers) Query(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-	page := web.Param(r, "page")
-	pageNumber, err := strconv.Atoi(page)
+	v, err := web.GetValues(ctx)
 	if err != nil {
-		return validate.NewRequestError(fmt.Errorf("invalid page format [%s]", page), http.StatusBadRequest)
+		return web.NewShutdownError("web value missing from context")
 	}
-	rows := web.Param(r, "rows")
-	rowsPerPage, err := strconv.Atoi(rows)
+
+	values := r.URL.Query()
+
+	filter, err := parseFilter(values)
 	if err != nil {
-		return validate.NewRequestError(fmt.Errorf("invalid rows format [%s]", rows), http.StatusBadRequest)
+		return validate.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	limit, err := parseLimit(values)
+	if err != nil {
+		return validate.NewRequestError(err, http.StatusBadRequest)
 	}
 
-	users, err := h.Task.Query(ctx, pageNumber, rowsPerPage)
+	ob, err := taskStore.ParseOrderBy(values.Get("sort"), values.Get("order"))
 	if err != nil {
-		return fmt.Errorf("unable to query for users: %w", err)
+		return validate.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	page, err := h.Task.Query(ctx, filter, ob, values.Get("cursor"), limit, v.Now)
+	if err != nil {
+		switch validate.Cause(err) {
+		case taskStore.ErrInvalidCursor:
+			return validate.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return fmt.Errorf("unable to query for tasks: %w", err)
+		}
+	}
+
+	if page.NextCursor != "" {
+		w.Header().Set("Link", nextLink(r, page.NextCursor))
 	}
 
-	return web.Respond(ctx, w, users, http.StatusOK)
+	return web.Respond(ctx, w, page, http.StatusOK)
+}
+
+func (h Handlers) QueryByID(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := web.Param(r, "id")
+	t, err := h.Task.QueryByID(ctx, id)
+	if err != nil {
+		switch validate.Cause(err) {
+		case validate.ErrInvalidID:
+			return validate.NewRequestError(err, http.StatusBadRequest)
+		case database.ErrNotFound:
+			return validate.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, t, http.StatusOK)
 }
 
 func (h Handlers) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
@@ -42,27 +82,71 @@ func (h Handlers) Create(ctx context.Context, w http.ResponseWriter, r *http.Req
 		return web.NewShutdownError("web value missing from context")
 	}
 
-	var url string
-	if err := web.Decode(r, &url); err != nil {
+	var ntr taskStore.NewTaskRequest
+	if err := web.Decode(r, &ntr); err != nil {
 		return fmt.Errorf("unable to decode payload: %w", err)
 	}
 
-	task, err := h.Task.Create(ctx, url, v.Now)
+	// a caller retrying a submission sends the same Idempotency-Key each time
+	var tasks []taskStore.Task
+	var created bool
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		tasks, created, err = h.Task.CreateOnce(ctx, key, ntr, v.Now)
+	} else {
+		tasks, created, err = h.Task.Create(ctx, ntr, v.Now)
+	}
 	if err != nil {
-		return fmt.Errorf("task[%+v]: %w", &task, err)
+		switch validate.Cause(err) {
+		case taskCore.ErrInvalidResource, taskCore.ErrInvalidIdempotencyKey, taskCore.ErrInvalidDelay, taskCore.ErrInvalidRetry:
+			return validate.NewRequestError(err, http.StatusBadRequest)
+		case taskCore.ErrNoTemplate, taskCore.ErrUnknownTemplate, taskCore.ErrIdempotencyConflict,
+			taskCore.ErrUnknownDependency, taskCore.ErrDependencyFailed:
+			return validate.NewRequestError(err, http.StatusUnprocessableEntity)
+		case taskCore.ErrDuplicateDepends:
+			return validate.NewRequestError(err, http.StatusConflict)
+		default:
+			return fmt.Errorf("input[%s]: %w", ntr.InputResource, err)
+		}
+	}
+
+	// a repeated or duplicate submission answers with the tasks already stored
+	status := http.StatusCreated
+	if !created {
+		status = http.StatusOK
 	}
 
-	return web.Respond(ctx, w, task, http.StatusCreated)
+	// a fan-out template answers with the group it created
+	if tasks[0].GroupID != nil {
+		group := taskCore.Summarize(*tasks[0].GroupID, tasks)
+		return web.Respond(ctx, w, group, status)
+	}
+
+	return web.Respond(ctx, w, tasks[0], status)
 }
 
+// Delete moves a finished task, or any task with ?force=true, to the trash
 func (h Handlers) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var force bool
+	if f := r.URL.Query().Get("force"); f != "" {
+		if force, err = strconv.ParseBool(f); err != nil {
+			return validate.NewRequestError(fmt.Errorf("invalid force format [%s]", f), http.StatusBadRequest)
+		}
+	}
+
 	id := web.Param(r, "id")
-	if err := h.Task.Delete(ctx, id); err != nil {
+	if err := h.Task.Delete(ctx, id, force, v.Now); err != nil {
 		switch validate.Cause(err) {
-		case database.ErrInvalidID:
+		case validate.ErrInvalidID:
 			return validate.NewRequestError(err, http.StatusBadRequest)
 		case database.ErrNotFound:
 			return validate.NewRequestError(err, http.StatusNotFound)
+		case taskCore.ErrTaskActive:
+			return validate.NewRequestError(err, http.StatusConflict)
 		case database.ErrForbidden:
 			return validate.NewRequestError(err, http.StatusForbidden)
 		default:
@@ -72,3 +156,312 @@ func (h Handlers) Delete(ctx context.Context, w http.ResponseWriter, r *http.Req
 
 	return web.Respond(ctx, w, nil, http.StatusNoContent)
 }
+
+func (h Handlers) UpdateStatus(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var us taskStore.UpdateStatus
+	if err := web.Decode(r, &us); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	id := web.Param(r, "id")
+	res, err := h.Task.UpdateStatus(ctx, id, us, v.Now)
+	if err != nil {
+		switch validate.Cause(err) {
+		case validate.ErrInvalidID, taskStore.ErrInvalidStatus, taskCore.ErrDuplicateResult:
+			return validate.NewRequestError(err, http.StatusBadRequest)
+		case taskCore.ErrResultsNotSucceeded, taskCore.ErrResultOutsideOutput, taskCore.ErrReportFailure:
+			return validate.NewRequestError(err, http.StatusUnprocessableEntity)
+		case database.ErrNotFound:
+			return validate.NewRequestError(err, http.StatusNotFound)
+		case database.ErrDBDuplicatedEntry, taskStore.ErrNotLeaseHolder:
+			return validate.NewRequestError(err, http.StatusConflict)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, res, http.StatusOK)
+}
+
+// Restore takes a task back out of the trash
+func (h Handlers) Restore(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	id := web.Param(r, "id")
+	res, err := h.Task.Restore(ctx, id, v.Now)
+	if err != nil {
+		switch validate.Cause(err) {
+		case validate.ErrInvalidID:
+			return validate.NewRequestError(err, http.StatusBadRequest)
+		case database.ErrNotFound:
+			return validate.NewRequestError(err, http.StatusNotFound)
+		case database.ErrDBDuplicatedEntry:
+			return validate.NewRequestError(err, http.StatusConflict)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, res, http.StatusOK)
+}
+
+// QueryTrash returns a page of the deleted tasks, most recently deleted first
+func (h Handlers) QueryTrash(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	page := web.Param(r, "page")
+	pageNumber, err := strconv.Atoi(page)
+	if err != nil || pageNumber < 1 {
+		return validate.NewRequestError(fmt.Errorf("invalid page format [%s]", page), http.StatusBadRequest)
+	}
+	rows := web.Param(r, "rows")
+	rowsPerPage, err := strconv.Atoi(rows)
+	if err != nil || rowsPerPage < 1 || rowsPerPage > taskCore.MaxPageSize {
+		return validate.NewRequestError(fmt.Errorf("invalid rows format [%s]", rows), http.StatusBadRequest)
+	}
+
+	tasks, err := h.Task.QueryTrash(ctx, pageNumber, rowsPerPage)
+	if err != nil {
+		return fmt.Errorf("unable to query for trash: %w", err)
+	}
+
+	return web.Respond(ctx, w, tasks, http.StatusOK)
+}
+
+// Cancel stops a task, telling the worker running it on its next heartbeat
+func (h Handlers) Cancel(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	id := web.Param(r, "id")
+	res, err := h.Task.Cancel(ctx, id, v.Now)
+	if err != nil {
+		switch validate.Cause(err) {
+		case validate.ErrInvalidID:
+			return validate.NewRequestError(err, http.StatusBadRequest)
+		case database.ErrNotFound:
+			return validate.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	// a worker still has to stop the task
+	status := http.StatusOK
+	if res.Status != taskStore.StatusCancelled {
+		status = http.StatusAccepted
+	}
+
+	return web.Respond(ctx, w, res, status)
+}
+
+// RunNow releases a scheduled task to be claimed straight away
+func (h Handlers) RunNow(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	id := web.Param(r, "id")
+	res, err := h.Task.RunNow(ctx, id, v.Now)
+	if err != nil {
+		switch validate.Cause(err) {
+		case validate.ErrInvalidID:
+			return validate.NewRequestError(err, http.StatusBadRequest)
+		case database.ErrNotFound:
+			return validate.NewRequestError(err, http.StatusNotFound)
+		case taskCore.ErrNotScheduled:
+			return validate.NewRequestError(err, http.StatusConflict)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, res, http.StatusOK)
+}
+
+func (h Handlers) UpdatePriority(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var up taskStore.UpdatePriority
+	if err := web.Decode(r, &up); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	id := web.Param(r, "id")
+	res, err := h.Task.UpdatePriority(ctx, id, up, v.Now)
+	if err != nil {
+		switch validate.Cause(err) {
+		case validate.ErrInvalidID:
+			return validate.NewRequestError(err, http.StatusBadRequest)
+		case database.ErrNotFound:
+			return validate.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, res, http.StatusOK)
+}
+
+func (h Handlers) QueryGroup(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := web.Param(r, "id")
+	group, err := h.Task.QueryGroup(ctx, id)
+	if err != nil {
+		switch validate.Cause(err) {
+		case validate.ErrInvalidID:
+			return validate.NewRequestError(err, http.StatusBadRequest)
+		case database.ErrNotFound:
+			return validate.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, group, http.StatusOK)
+}
+
+// CreateDAG submits a set of tasks that depend on one another in one request
+func (h Handlers) CreateDAG(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var nd taskStore.NewDAG
+	if err := web.Decode(r, &nd); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	dag, err := h.Task.CreateDAG(ctx, nd, v.Now)
+	if err != nil {
+		switch validate.Cause(err) {
+		case taskCore.ErrInvalidResource, taskCore.ErrInvalidDelay, taskCore.ErrInvalidRetry, taskCore.ErrDuplicateKey:
+			return validate.NewRequestError(err, http.StatusBadRequest)
+		case taskCore.ErrNoTemplate, taskCore.ErrUnknownTemplate, taskCore.ErrCycle,
+			taskCore.ErrUnknownDependency, taskCore.ErrDependencyFailed:
+			return validate.NewRequestError(err, http.StatusUnprocessableEntity)
+		case taskCore.ErrDuplicateDepends:
+			return validate.NewRequestError(err, http.StatusConflict)
+		default:
+			return fmt.Errorf("create dag: %w", err)
+		}
+	}
+
+	return web.Respond(ctx, w, dag, http.StatusCreated)
+}
+
+// QueryGraph returns the tasks a task depends on, and that depend on it, with the edges between them
+func (h Handlers) QueryGraph(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := web.Param(r, "id")
+	graph, err := h.Task.QueryGraph(ctx, id)
+	if err != nil {
+		switch validate.Cause(err) {
+		case validate.ErrInvalidID:
+			return validate.NewRequestError(err, http.StatusBadRequest)
+		case database.ErrNotFound:
+			return validate.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, graph, http.StatusOK)
+}
+
+// QueryEvents returns the history of a task, oldest first
+func (h Handlers) QueryEvents(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := web.Param(r, "id")
+	events, err := h.Task.QueryEvents(ctx, id)
+	if err != nil {
+		switch validate.Cause(err) {
+		case validate.ErrInvalidID:
+			return validate.NewRequestError(err, http.StatusBadRequest)
+		case database.ErrNotFound:
+			return validate.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, events, http.StatusOK)
+}
+
+// QueryProgress returns the progress reports a task's workers have made, oldest first
+func (h Handlers) QueryProgress(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := web.Param(r, "id")
+	reports, err := h.Task.QueryProgress(ctx, id)
+	if err != nil {
+		switch validate.Cause(err) {
+		case validate.ErrInvalidID:
+			return validate.NewRequestError(err, http.StatusBadRequest)
+		case database.ErrNotFound:
+			return validate.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, reports, http.StatusOK)
+}
+
+// AddResults records the manifest of objects a succeeded task produced
+func (h Handlers) AddResults(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var ar taskStore.AddResults
+	if err := web.Decode(r, &ar); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	id := web.Param(r, "id")
+	results, err := h.Task.AddResults(ctx, id, ar, v.Now)
+	if err != nil {
+		switch validate.Cause(err) {
+		case validate.ErrInvalidID, taskCore.ErrDuplicateResult:
+			return validate.NewRequestError(err, http.StatusBadRequest)
+		case taskCore.ErrResultsNotSucceeded, taskCore.ErrResultOutsideOutput:
+			return validate.NewRequestError(err, http.StatusUnprocessableEntity)
+		case database.ErrNotFound:
+			return validate.NewRequestError(err, http.StatusNotFound)
+		case taskStore.ErrNotLeaseHolder:
+			return validate.NewRequestError(err, http.StatusConflict)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, results, http.StatusOK)
+}
+
+// QueryResults returns the manifest of objects a task produced
+func (h Handlers) QueryResults(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := web.Param(r, "id")
+	results, err := h.Task.QueryResults(ctx, id)
+	if err != nil {
+		switch validate.Cause(err) {
+		case validate.ErrInvalidID:
+			return validate.NewRequestError(err, http.StatusBadRequest)
+		case database.ErrNotFound:
+			return validate.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, results, http.StatusOK)
+}
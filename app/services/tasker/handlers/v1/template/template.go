@@ -0,0 +1,48 @@
+package template
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	taskCore "github.com/jnkroeker/khyme/business/core/task"
+	"github.com/jnkroeker/khyme/business/data/store/task"
+	"github.com/jnkroeker/khyme/business/sys/validate"
+	"github.com/jnkroeker/khyme/foundation/web"
+)
+
+type Handlers struct {
+	Task taskCore.Core
+}
+
+// Query lists the registered templates in the order submissions are matched against them
+func (h Handlers) Query(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	return web.Respond(ctx, w, h.Task.Templates(), http.StatusOK)
+}
+
+// DryRun returns the tasks a submission would create, without creating them
+func (h Handlers) DryRun(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var ntr task.NewTaskRequest
+	if err := web.Decode(r, &ntr); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	match, err := h.Task.DryRun(ctx, ntr, v.Now)
+	if err != nil {
+		switch validate.Cause(err) {
+		case taskCore.ErrInvalidResource, taskCore.ErrInvalidDelay, taskCore.ErrInvalidRetry:
+			return validate.NewRequestError(err, http.StatusBadRequest)
+		case taskCore.ErrNoTemplate, taskCore.ErrUnknownTemplate:
+			return validate.NewRequestError(err, http.StatusUnprocessableEntity)
+		default:
+			return fmt.Errorf("input[%s]: %w", ntr.InputResource, err)
+		}
+	}
+
+	return web.Respond(ctx, w, match, http.StatusOK)
+}
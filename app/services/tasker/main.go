@@ -17,7 +17,11 @@ import (
 
 	"github.com/ardanlabs/conf"
 	"github.com/jnkroeker/khyme/app/services/tasker/handlers"
+	queueCore "github.com/jnkroeker/khyme/business/core/queue"
+	scheduleCore "github.com/jnkroeker/khyme/business/core/schedule"
+	taskCore "github.com/jnkroeker/khyme/business/core/task"
 	"github.com/jnkroeker/khyme/business/sys/database"
+	"github.com/jnkroeker/khyme/foundation/periodic"
 	"github.com/joho/godotenv"
 	"go.uber.org/automaxprocs/maxprocs"
 	"go.uber.org/zap"
@@ -75,16 +79,33 @@ func run(log *zap.SugaredLogger) error {
 	cfg := struct {
 		conf.Version
 		Task struct {
-			ServiceHost     string        `conf:"default:0.0.0.0:3000"`
-			DebugHost       string        `conf:"default:0.0.0.0:4000"`
-			ReadTimeout     time.Duration `conf:"default:5s"`
-			WriteTimeout    time.Duration `conf:"default:10s"`
-			IdleTimeout     time.Duration `conf:"default:120s"`
-			ShutdownTimeout time.Duration `conf:"default:20s,mask"`
-			Set             string        `conf:"default:TASKS"`
-			Queue           string        `conf:"default:Q"`
-			Dlq             string        `conf:"default:DLQ"`
-			BatchSize       int           `conf:"default:0"`
+			ServiceHost       string        `conf:"default:0.0.0.0:3000"`
+			DebugHost         string        `conf:"default:0.0.0.0:4000"`
+			ReadTimeout       time.Duration `conf:"default:5s"`
+			WriteTimeout      time.Duration `conf:"default:10s"`
+			IdleTimeout       time.Duration `conf:"default:120s"`
+			ShutdownTimeout   time.Duration `conf:"default:20s,mask"`
+			Set               string        `conf:"default:TASKS"`
+			Queue             string        `conf:"default:Q"`
+			Dlq               string        `conf:"default:DLQ"`
+			BatchSize         int           `conf:"default:1"`
+			LeaseDuration     time.Duration `conf:"default:5m"`
+			HeartbeatInterval time.Duration `conf:"default:1m"`
+			ReaperInterval    time.Duration `conf:"default:30s"`
+			MaxAttempts       int           `conf:"default:3"`
+			RetryBase         time.Duration `conf:"default:30s"`
+			RetryMax          time.Duration `conf:"default:1h"`
+			RetryJitter       float64       `conf:"default:0.2"`
+			PriorityAging     time.Duration `conf:"default:10m"`
+			ProgressHistory   bool          `conf:"default:false"`
+			Templates         string        `conf:"default:zarf/templates/templates.json"`
+			ProbeTimeout      time.Duration `conf:"default:5s"`
+			ProbeHosts        []string      `conf:"help:hosts content types may be probed on separated by ;"`
+			IdempotencyWindow time.Duration `conf:"default:24h"`
+			PurgeInterval     time.Duration `conf:"default:1h"`
+			TrashRetention    time.Duration `conf:"default:720h"`
+			ScheduleInterval  time.Duration `conf:"default:30s"`
+			ListTimeout       time.Duration `conf:"default:1m"`
 		}
 		DB struct {
 			User         string `conf:"default:postgres"`
@@ -127,6 +148,26 @@ func run(log *zap.SugaredLogger) error {
 		return fmt.Errorf("parsing config: %w", err)
 	}
 
+	// the background loops tick on these intervals, and a lease that runs out
+	// between heartbeats would be reaped from a healthy worker
+	intervals := []struct {
+		name string
+		d    time.Duration
+	}{
+		{"heartbeat-interval", cfg.Task.HeartbeatInterval},
+		{"reaper-interval", cfg.Task.ReaperInterval},
+		{"purge-interval", cfg.Task.PurgeInterval},
+		{"schedule-interval", cfg.Task.ScheduleInterval},
+	}
+	for _, i := range intervals {
+		if i.d <= 0 {
+			return fmt.Errorf("parsing config: %s must be positive, got %s", i.name, i.d)
+		}
+	}
+	if cfg.Task.LeaseDuration <= cfg.Task.HeartbeatInterval {
+		return fmt.Errorf("parsing config: lease-duration %s must be longer than heartbeat-interval %s", cfg.Task.LeaseDuration, cfg.Task.HeartbeatInterval)
+	}
+
 	// ========================================================================================
 	// App Starting
 
@@ -182,6 +223,100 @@ func run(log *zap.SugaredLogger) error {
 		}
 	}()
 
+	// ========================================================================================
+	// Task Templates
+
+	// Templates are declared in a file so a new media type is a config change
+	log.Infow("startup", "status", "loading task templates", "file", cfg.Task.Templates)
+
+	templates, err := taskCore.LoadTemplates(cfg.Task.Templates)
+	if err != nil {
+		return fmt.Errorf("loading templates: %w", err)
+	}
+
+	// Pipelines chain the templates, so they are checked against them
+	pipelines, err := taskCore.LoadPipelines(cfg.Task.Templates, templates)
+	if err != nil {
+		return fmt.Errorf("loading pipelines: %w", err)
+	}
+
+	// Every task is stamped with the build that templated it.
+	// Templates matching on content type probe http resources for it,
+	// never reaching addresses inside the cluster.
+	resolver := taskCore.NewHTTPResolver(cfg.Task.ProbeTimeout, cfg.Task.ProbeHosts)
+	templater := taskCore.NewTemplater(templates, build).WithResolver(resolver).WithPipelines(pipelines)
+
+	// ========================================================================================
+	// Start Lease Reaper
+
+	log.Infow("startup", "status", "lease reaper started", "interval", cfg.Task.ReaperInterval)
+
+	queueCfg := queueCore.Config{
+		Name:              cfg.Task.Queue,
+		BatchSize:         cfg.Task.BatchSize,
+		LeaseDuration:     cfg.Task.LeaseDuration,
+		HeartbeatInterval: cfg.Task.HeartbeatInterval,
+		Dlq:               cfg.Task.Dlq,
+		MaxAttempts:       cfg.Task.MaxAttempts,
+		RetryBase:         cfg.Task.RetryBase,
+		RetryMax:          cfg.Task.RetryMax,
+		RetryJitter:       cfg.Task.RetryJitter,
+		PriorityAging:     cfg.Task.PriorityAging,
+		ProgressHistory:   cfg.Task.ProgressHistory,
+	}
+
+	// Tasks whose worker stopped heartbeating are put back on the queue.
+	// The reaper is a child of this goroutine and is stopped during shutdown.
+	queue := queueCore.NewCore(log, db, queueCfg)
+	reaper := periodic.Start(cfg.Task.ReaperInterval, func(ctx context.Context) {
+		if _, err := queue.Reap(ctx, time.Now()); err != nil {
+			log.Errorw("reaper", "status", "reaping expired leases", "ERROR", err)
+		}
+	})
+
+	// ========================================================================================
+	// Start Purge
+
+	log.Infow("startup", "status", "purge started", "interval", cfg.Task.PurgeInterval)
+
+	taskCfg := taskCore.Config{
+		IdempotencyWindow: cfg.Task.IdempotencyWindow,
+		TrashRetention:    cfg.Task.TrashRetention,
+	}
+
+	// Idempotency keys are only honoured inside their window, so older ones are removed.
+	// Deleted tasks can be restored from the trash until their retention runs out.
+	// The purge is a child of this goroutine and is stopped during shutdown.
+	task := taskCore.NewCore(log, db, templater, taskCfg)
+	purge := periodic.Start(cfg.Task.PurgeInterval, func(ctx context.Context) {
+		if _, err := task.PurgeIdempotencyKeys(ctx, time.Now()); err != nil {
+			log.Errorw("purge", "status", "purging idempotency keys", "ERROR", err)
+		}
+		if _, err := task.PurgeTrash(ctx, time.Now()); err != nil {
+			log.Errorw("purge", "status", "purging trash", "ERROR", err)
+		}
+	})
+
+	// ========================================================================================
+	// Start Scheduler
+
+	log.Infow("startup", "status", "scheduler started", "interval", cfg.Task.ScheduleInterval)
+
+	// Schedules on a bucket prefix submit every object listed under it
+	lister := scheduleCore.BucketLister{
+		Client: &http.Client{Timeout: cfg.Task.ListTimeout},
+	}
+
+	// Due schedules create their tasks. Every replica runs the scheduler;
+	// a lock on each schedule stops an occurrence firing more than once.
+	// The scheduler is a child of this goroutine and is stopped during shutdown.
+	schedules := scheduleCore.NewCore(log, db, task, lister)
+	scheduler := periodic.Start(cfg.Task.ScheduleInterval, func(ctx context.Context) {
+		if _, err := schedules.Fire(ctx, time.Now()); err != nil {
+			log.Errorw("scheduler", "status", "firing schedules", "ERROR", err)
+		}
+	})
+
 	// ========================================================================================
 	// Start API Service
 
@@ -194,9 +329,13 @@ func run(log *zap.SugaredLogger) error {
 
 	// Construct the MUX for the API calls
 	apiMux := handlers.APIMux(handlers.APIMuxConfig{
-		Shutdown: shutdown,
-		Log:      log,
-		DB:       db,
+		Shutdown:  shutdown,
+		Log:       log,
+		DB:        db,
+		Queue:     queueCfg,
+		Task:      taskCfg,
+		Templater: templater,
+		Lister:    lister,
 	})
 
 	// In order to implement load-shedding, (aka on shutdown the goroutines currently handling requests can complete)
@@ -240,12 +379,25 @@ func run(log *zap.SugaredLogger) error {
 	// On a ctrl-c or a kubernetes shutdown message we can load-shed
 	select {
 	case err := <-serverErrors:
+		reaper.Stop()
+		purge.Stop()
+		scheduler.Stop()
 		return fmt.Errorf("server error: %w", err)
 
 	case sig := <-shutdown:
 		log.Infow("shutdown", "status", "Tasker shutdown started", "signal", sig)
 		defer log.Infow("shutdown", "status", " Tasker shutdown complete", "signal", sig)
 
+		// Stop reaping before the api goes away
+		log.Infow("shutdown", "status", "stopping lease reaper")
+		reaper.Stop()
+
+		log.Infow("shutdown", "status", "stopping purge")
+		purge.Stop()
+
+		log.Infow("shutdown", "status", "stopping scheduler")
+		scheduler.Stop()
+
 		// Give outstanding requests a deadline for completion.
 		ctx, cancel := context.WithTimeout(context.Background(), cfg.Task.ShutdownTimeout)
 		defer cancel()
@@ -0,0 +1,97 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jnkroeker/khyme/business/data/store/event"
+	"github.com/jnkroeker/khyme/business/data/store/queue"
+	"github.com/jnkroeker/khyme/business/data/store/task"
+	"github.com/jnkroeker/khyme/business/sys/database"
+	"github.com/jnkroeker/khyme/business/sys/validate"
+)
+
+// ErrNotFailed is returned when a dead-lettered task was moved out of the
+// failed status, so it cannot be put back on the queue
+var ErrNotFailed = errors.New("task is no longer failed")
+
+func (c Core) QueryDeadLetters(ctx context.Context, pageNumber int, rowsPerPage int) ([]queue.DeadLetter, error) {
+
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	dls, err := c.queue.QueryDeadLetters(ctx, pageNumber, rowsPerPage)
+	if err != nil {
+		return nil, fmt.Errorf("query dead letters: %w", err)
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	return dls, nil
+}
+
+// Requeue takes a task off the dead-letter queue and puts it back on the
+// work queue with a fresh set of attempts.
+func (c Core) Requeue(ctx context.Context, taskID string, now time.Time) error {
+
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	if err := validate.CheckID(taskID); err != nil {
+		return err
+	}
+
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.queue.Tran(tx)
+
+		if err := store.RemoveDeadLetter(ctx, taskID); err != nil {
+			return err
+		}
+		if err := store.Requeue(ctx, taskID); err != nil {
+			if errors.Is(err, database.ErrNotFound) {
+				return ErrNotFailed
+			}
+			return err
+		}
+
+		ne := event.NewEvent{
+			TaskID: taskID,
+			Type:   event.TypeRequeued,
+			From:   task.StatusFailed,
+			To:     task.StatusPending,
+			Actor:  event.Caller(ctx),
+		}
+		_, err := c.event.Tran(tx).Record(ctx, ne, now)
+		return err
+	}
+
+	if err := c.queue.WithinTran(ctx, tran); err != nil {
+		return fmt.Errorf("requeue: %w", err)
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	c.log.Infow("requeue", "queue", c.cfg.Name, "task", taskID)
+
+	return nil
+}
+
+// DeleteDeadLetter discards a task from the dead-letter queue.
+// The task itself is kept in its failed state.
+func (c Core) DeleteDeadLetter(ctx context.Context, taskID string) error {
+
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	if err := validate.CheckID(taskID); err != nil {
+		return err
+	}
+
+	if err := c.queue.RemoveDeadLetter(ctx, taskID); err != nil {
+		return fmt.Errorf("delete dead letter: %w", err)
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	return nil
+}
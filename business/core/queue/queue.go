@@ -0,0 +1,465 @@
+// Package queue provides the business logic for handing tasks to workers
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jnkroeker/khyme/business/data/store/event"
+	"github.com/jnkroeker/khyme/business/data/store/pipeline"
+	"github.com/jnkroeker/khyme/business/data/store/queue"
+	"github.com/jnkroeker/khyme/business/data/store/task"
+	"github.com/jnkroeker/khyme/business/sys/database"
+	"github.com/jnkroeker/khyme/business/sys/validate"
+	"go.uber.org/zap"
+)
+
+// Set of error variables
+var (
+	ErrWorkerRequired = errors.New("worker_id is required")
+	ErrLeaseLost      = errors.New("worker does not hold a lease on the task")
+)
+
+// Config contains the settings the queue needs from the service configuration
+type Config struct {
+	Name              string
+	BatchSize         int
+	LeaseDuration     time.Duration
+	HeartbeatInterval time.Duration
+	Dlq               string
+	MaxAttempts       int
+	RetryBase         time.Duration
+	RetryMax          time.Duration
+	RetryJitter       float64
+	PriorityAging     time.Duration
+	ProgressHistory   bool
+}
+
+type Core struct {
+	log      *zap.SugaredLogger
+	cfg      Config
+	queue    queue.Store
+	task     task.Store
+	pipeline pipeline.Store
+	event    event.Store
+}
+
+func NewCore(log *zap.SugaredLogger, db *sqlx.DB, cfg Config) Core {
+	if cfg.BatchSize < 1 {
+		cfg.BatchSize = 1
+	}
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 1
+	}
+
+	return Core{
+		log:      log,
+		cfg:      cfg,
+		queue:    queue.NewStore(log, db),
+		task:     task.NewStore(log, db),
+		pipeline: pipeline.NewStore(log, db),
+		event:    event.NewStore(log, db),
+	}
+}
+
+// Name returns the name of the queue this Core serves
+func (c Core) Name() string {
+	return c.cfg.Name
+}
+
+// HeartbeatInterval returns how often workers should extend their leases
+func (c Core) HeartbeatInterval() time.Duration {
+	return c.cfg.HeartbeatInterval
+}
+
+func (c Core) Claim(ctx context.Context, cl queue.Claim, now time.Time) ([]task.Task, error) {
+
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	if cl.WorkerID == "" {
+		return nil, ErrWorkerRequired
+	}
+
+	// a worker may ask for fewer tasks than the batch size, never more
+	limit := c.cfg.BatchSize
+	if cl.Max > 0 && cl.Max < limit {
+		limit = cl.Max
+	}
+
+	var tasks []task.Task
+	tran := func(tx sqlx.ExtContext) error {
+		var err error
+		tasks, err = c.queue.Tran(tx).Claim(ctx, cl.WorkerID, limit, now, now.Add(c.cfg.LeaseDuration), c.cfg.PriorityAging)
+		if err != nil {
+			return err
+		}
+
+		for _, t := range tasks {
+			ne := event.NewEvent{
+				TaskID:  t.ID,
+				Type:    event.TypeClaimed,
+				From:    task.StatusPending,
+				To:      t.Status,
+				Actor:   event.Worker(cl.WorkerID),
+				Message: fmt.Sprintf("attempt %d", t.Attempt),
+			}
+			if _, err := c.event.Tran(tx).Record(ctx, ne, now); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if err := c.queue.WithinTran(ctx, tran); err != nil {
+		return nil, fmt.Errorf("claim: %w", err)
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	c.log.Infow("claim", "queue", c.cfg.Name, "worker", cl.WorkerID, "claimed", len(tasks))
+
+	return tasks, nil
+}
+
+func (c Core) Heartbeat(ctx context.Context, taskID string, hb queue.Heartbeat, now time.Time) (queue.Lease, error) {
+
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	if err := validate.CheckID(taskID); err != nil {
+		return queue.Lease{}, err
+	}
+
+	if hb.WorkerID == "" {
+		return queue.Lease{}, ErrWorkerRequired
+	}
+
+	expires := now.Add(c.cfg.LeaseDuration)
+
+	var tasks []task.Task
+	tran := func(tx sqlx.ExtContext) error {
+		prev, err := c.task.Tran(tx).QueryByID(ctx, taskID)
+		if err != nil {
+			if errors.Is(err, database.ErrNotFound) {
+				return ErrLeaseLost
+			}
+			return err
+		}
+
+		if tasks, err = c.queue.Tran(tx).Heartbeat(ctx, taskID, hb.WorkerID, expires); err != nil {
+			return err
+		}
+
+		// the task was reaped, finished, or claimed by another worker
+		if len(tasks) == 0 {
+			return ErrLeaseLost
+		}
+
+		return c.heartbeatGap(ctx, tx, prev, hb.WorkerID, now)
+	}
+
+	if err := c.queue.WithinTran(ctx, tran); err != nil {
+		if errors.Is(err, ErrLeaseLost) {
+			return queue.Lease{}, ErrLeaseLost
+		}
+		return queue.Lease{}, fmt.Errorf("heartbeat: %w", err)
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	lease := queue.Lease{
+		TaskID:            taskID,
+		ExpiresAt:         expires,
+		HeartbeatInterval: c.cfg.HeartbeatInterval,
+		Cancel:            tasks[0].CancelRequestedAt != nil,
+	}
+
+	return lease, nil
+}
+
+// Progress stores the latest progress a worker reported on a task it holds.
+// Each report is also kept in the history of the task when the queue is
+// configured to keep progress history.
+func (c Core) Progress(ctx context.Context, taskID string, p queue.Progress, now time.Time) (task.Task, error) {
+
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	if err := validate.CheckID(taskID); err != nil {
+		return task.Task{}, err
+	}
+
+	if p.WorkerID == "" {
+		return task.Task{}, ErrWorkerRequired
+	}
+
+	progress := task.Progress{
+		Percent:     p.Percent,
+		Stage:       p.Stage,
+		Bytes:       p.Bytes,
+		Message:     p.Message,
+		DateUpdated: now,
+	}
+
+	var res task.Task
+	tran := func(tx sqlx.ExtContext) error {
+		tasks, err := c.queue.Tran(tx).Progress(ctx, taskID, p.WorkerID, progress)
+		if err != nil {
+			return err
+		}
+
+		// the task was reaped, finished, or claimed by another worker
+		if len(tasks) == 0 {
+			return ErrLeaseLost
+		}
+		res = tasks[0]
+
+		if !c.cfg.ProgressHistory {
+			return nil
+		}
+
+		pr := task.ProgressReport{
+			ID:          validate.GenerateID(),
+			TaskID:      res.ID,
+			WorkerID:    p.WorkerID,
+			Attempt:     res.Attempt,
+			Percent:     p.Percent,
+			Stage:       p.Stage,
+			Bytes:       p.Bytes,
+			Message:     p.Message,
+			DateCreated: now,
+		}
+		return c.task.Tran(tx).AddProgress(ctx, pr)
+	}
+
+	if err := c.queue.WithinTran(ctx, tran); err != nil {
+		if errors.Is(err, ErrLeaseLost) {
+			return task.Task{}, ErrLeaseLost
+		}
+		return task.Task{}, fmt.Errorf("progress: %w", err)
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	return res, nil
+}
+
+// heartbeatGap records that the worker went quiet for longer than two
+// heartbeat intervals before this beat. The last beat is worked out from
+// the lease it set.
+func (c Core) heartbeatGap(ctx context.Context, tx sqlx.ExtContext, prev task.Task, workerID string, now time.Time) error {
+	if prev.LeaseExpiresAt == nil || c.cfg.HeartbeatInterval <= 0 {
+		return nil
+	}
+
+	gap := now.Sub(prev.LeaseExpiresAt.Add(-c.cfg.LeaseDuration))
+	if gap <= 2*c.cfg.HeartbeatInterval {
+		return nil
+	}
+
+	ne := event.NewEvent{
+		TaskID:  prev.ID,
+		Type:    event.TypeHeartbeatGap,
+		Actor:   event.Worker(workerID),
+		Message: fmt.Sprintf("no heartbeat for %s", gap.Round(time.Second)),
+	}
+	_, err := c.event.Tran(tx).Record(ctx, ne, now)
+	return err
+}
+
+// Fail records a failed run reported by a worker. The task goes back on
+// the queue until it has used up its attempts, then to the dead-letter queue.
+func (c Core) Fail(ctx context.Context, taskID string, f queue.Failure, now time.Time) (task.Task, error) {
+
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	if err := validate.CheckID(taskID); err != nil {
+		return task.Task{}, err
+	}
+
+	if f.WorkerID == "" {
+		return task.Task{}, ErrWorkerRequired
+	}
+
+	var res task.Task
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.queue.Tran(tx)
+
+		tasks, err := store.Fail(ctx, taskID, f.WorkerID, f.Error, now)
+		if err != nil {
+			return err
+		}
+
+		// the task was reaped, finished, or claimed by another worker
+		if len(tasks) == 0 {
+			return ErrLeaseLost
+		}
+		res = tasks[0]
+
+		ne := event.NewEvent{
+			TaskID:  res.ID,
+			Type:    event.TypeFailed,
+			To:      res.Status,
+			Actor:   event.Worker(f.WorkerID),
+			Message: f.Error,
+		}
+		if _, err := c.event.Tran(tx).Record(ctx, ne, now); err != nil {
+			return err
+		}
+
+		return c.afterFailure(ctx, tx, res, now)
+	}
+
+	if err := c.queue.WithinTran(ctx, tran); err != nil {
+		return task.Task{}, fmt.Errorf("fail: %w", err)
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	return res, nil
+}
+
+// afterFailure decides what happens to a task that just failed: retry it
+// after a backoff, or set it aside on the dead-letter queue once it has
+// no attempts left. A task that was asked to be cancelled is never retried.
+func (c Core) afterFailure(ctx context.Context, tx sqlx.ExtContext, t task.Task, now time.Time) error {
+	if t.Status == task.StatusCancelled {
+		c.log.Infow("cancelled", "queue", c.cfg.Name, "task", t.ID)
+		return c.finish(ctx, tx, t, now)
+	}
+
+	p := c.policy(t.RetryPolicy)
+
+	if t.Attempt < p.MaxAttempts {
+		notBefore := now.Add(backoff(p, t.Attempt))
+		c.log.Infow("retry", "queue", c.cfg.Name, "task", t.ID, "attempt", t.Attempt, "notbefore", notBefore)
+		if err := c.queue.Tran(tx).Retry(ctx, t.ID, &notBefore); err != nil {
+			return err
+		}
+
+		ne := event.NewEvent{
+			TaskID:  t.ID,
+			Type:    event.TypeRetryScheduled,
+			From:    task.StatusFailed,
+			To:      task.StatusPending,
+			Actor:   event.ActorSystem,
+			Message: fmt.Sprintf("attempt %d of %d, not before %s", t.Attempt, p.MaxAttempts, notBefore.Format(time.RFC3339)),
+		}
+		_, err := c.event.Tran(tx).Record(ctx, ne, now)
+		return err
+	}
+
+	return c.deadLetter(ctx, tx, t, now)
+}
+
+// deadLetter sets a task that has failed for good aside on the dead-letter queue
+func (c Core) deadLetter(ctx context.Context, tx sqlx.ExtContext, t task.Task, now time.Time) error {
+	if _, err := c.queue.Tran(tx).DeadLetter(ctx, t, c.cfg.Dlq, now); err != nil {
+		return err
+	}
+	c.log.Infow("dead letter", "queue", c.cfg.Dlq, "task", t.ID, "attempts", t.Attempt)
+
+	ne := event.NewEvent{
+		TaskID:  t.ID,
+		Type:    event.TypeDeadLettered,
+		Actor:   event.ActorSystem,
+		Message: fmt.Sprintf("%s after %d attempts", c.cfg.Dlq, t.Attempt),
+	}
+	if _, err := c.event.Tran(tx).Record(ctx, ne, now); err != nil {
+		return err
+	}
+
+	return c.finish(ctx, tx, t, now)
+}
+
+// finish ends the pipeline run a task that failed or was cancelled is the
+// current stage of. Tasks waiting on it can no longer run, so they are
+// cancelled, along with any runs they are the current stage of.
+func (c Core) finish(ctx context.Context, tx sqlx.ExtContext, t task.Task, now time.Time) error {
+	runs := c.pipeline.Tran(tx)
+	if err := runs.Stop(ctx, t.ID, t.Status, fmt.Sprintf("task %s %s", t.ID, t.Status), now); err != nil {
+		return err
+	}
+
+	reason := fmt.Sprintf("dependency %s %s", t.ID, t.Status)
+	cancelled, err := c.task.Tran(tx).CancelDependents(ctx, t.ID, reason, now)
+	if err != nil {
+		return err
+	}
+	if err := c.event.Tran(tx).RecordStatus(ctx, cancelled, task.StatusPending, event.ActorSystem, reason, now); err != nil {
+		return err
+	}
+	for _, d := range cancelled {
+		c.log.Infow("cancel dependent", "task", d.ID, "dependency", t.ID)
+		if err := runs.Stop(ctx, d.ID, task.StatusCancelled, fmt.Sprintf("task %s cancelled", d.ID), now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reap returns tasks whose lease has expired to the queue. Tasks that have
+// used all their attempts are moved to the dead-letter queue instead, and
+// tasks that were asked to be cancelled are cancelled.
+func (c Core) Reap(ctx context.Context, now time.Time) ([]task.Task, error) {
+
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	var requeued []task.Task
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.queue.Tran(tx)
+
+		var failed, cancelled []task.Task
+		var err error
+		requeued, failed, cancelled, err = store.Reap(ctx, now, c.cfg.MaxAttempts)
+		if err != nil {
+			return err
+		}
+
+		for _, ts := range [][]task.Task{cancelled, failed, requeued} {
+			for _, t := range ts {
+				ne := event.NewEvent{
+					TaskID:  t.ID,
+					Type:    event.TypeLeaseExpired,
+					To:      t.Status,
+					Actor:   event.ActorReaper,
+					Message: fmt.Sprintf("attempt %d", t.Attempt),
+				}
+				if _, err := c.event.Tran(tx).Record(ctx, ne, now); err != nil {
+					return err
+				}
+			}
+		}
+
+		// a worker asked to cancel a task stopped without saying so
+		for _, t := range cancelled {
+			c.log.Infow("cancelled", "queue", c.cfg.Name, "task", t.ID)
+			if err := c.finish(ctx, tx, t, now); err != nil {
+				return err
+			}
+		}
+
+		for _, t := range failed {
+			if err := c.deadLetter(ctx, tx, t, now); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if err := c.queue.WithinTran(ctx, tran); err != nil {
+		return nil, fmt.Errorf("reap: %w", err)
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	for _, t := range requeued {
+		c.log.Infow("reap", "queue", c.cfg.Name, "task", t.ID, "attempt", t.Attempt)
+	}
+
+	return requeued, nil
+}
@@ -0,0 +1,62 @@
+package queue
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/jnkroeker/khyme/business/data/store/task"
+)
+
+// retryPolicy is the retry policy of a task with the queue defaults
+// filled in for the fields it leaves unset
+type retryPolicy struct {
+	MaxAttempts int
+	Base        time.Duration
+	Max         time.Duration
+	Jitter      float64
+}
+
+// policy fills the parts of a task's retry policy it leaves unset
+// with the queue defaults.
+func (c Core) policy(p task.RetryPolicy) retryPolicy {
+	rp := retryPolicy{
+		MaxAttempts: c.cfg.MaxAttempts,
+		Base:        c.cfg.RetryBase,
+		Max:         c.cfg.RetryMax,
+		Jitter:      c.cfg.RetryJitter,
+	}
+	if p.MaxAttempts != nil {
+		rp.MaxAttempts = *p.MaxAttempts
+	}
+	if p.Base != nil {
+		rp.Base = *p.Base
+	}
+	if p.Max != nil {
+		rp.Max = *p.Max
+	}
+	if p.Jitter != nil {
+		rp.Jitter = *p.Jitter
+	}
+	return rp
+}
+
+// backoff returns how long to wait before running a task again after its
+// attempt-th run failed. The wait doubles with every attempt up to the
+// policy maximum, then up to a Jitter fraction of it is taken off at random
+// so tasks that failed together do not all come back together.
+func backoff(p retryPolicy, attempt int) time.Duration {
+	delay := p.Base
+	for i := 1; i < attempt && delay < p.Max; i++ {
+		delay *= 2
+	}
+	if delay > p.Max {
+		delay = p.Max
+	}
+
+	jitter := p.Jitter
+	if jitter > 1 {
+		jitter = 1
+	}
+
+	return delay - time.Duration(rand.Float64()*jitter*float64(delay))
+}
@@ -0,0 +1,88 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jnkroeker/khyme/business/data/store/task"
+)
+
+func TestBackoff(t *testing.T) {
+	p := retryPolicy{Base: time.Second, Max: time.Minute}
+
+	tests := []struct {
+		name    string
+		attempt int
+		want    time.Duration
+	}{
+		{"first attempt", 1, time.Second},
+		{"second attempt", 2, 2 * time.Second},
+		{"third attempt", 3, 4 * time.Second},
+		{"seventh attempt", 7, 60 * time.Second},
+		{"capped", 8, time.Minute},
+		{"far past the cap", 1000, time.Minute},
+		{"attempt zero", 0, time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := backoff(p, tt.attempt); got != tt.want {
+				t.Fatalf("backoff(%d) = %s, want %s", tt.attempt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	tests := []struct {
+		name   string
+		jitter float64
+		min    time.Duration
+	}{
+		{"quarter", 0.25, 6 * time.Second},
+		{"all of it", 1, 0},
+		{"more than all of it", 5, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := retryPolicy{Base: time.Second, Max: time.Minute, Jitter: tt.jitter}
+			for i := 0; i < 1000; i++ {
+				got := backoff(p, 4)
+				if got < tt.min || got > 8*time.Second {
+					t.Fatalf("backoff(4) = %s, want between %s and 8s", got, tt.min)
+				}
+			}
+		})
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	c := Core{cfg: Config{MaxAttempts: 5, RetryBase: time.Second, RetryMax: time.Hour, RetryJitter: 0.2}}
+
+	one, zero := 1, 0.0
+	minute, twoMinutes := time.Minute, 2*time.Minute
+	half := 0.5
+
+	tests := []struct {
+		name string
+		p    task.RetryPolicy
+		want retryPolicy
+	}{
+		{"unset", task.RetryPolicy{}, retryPolicy{MaxAttempts: 5, Base: time.Second, Max: time.Hour, Jitter: 0.2}},
+		{"all set", task.RetryPolicy{MaxAttempts: &one, Base: &minute, Max: &twoMinutes, Jitter: &half},
+			retryPolicy{MaxAttempts: 1, Base: time.Minute, Max: 2 * time.Minute, Jitter: 0.5}},
+		{"some set", task.RetryPolicy{MaxAttempts: &one},
+			retryPolicy{MaxAttempts: 1, Base: time.Second, Max: time.Hour, Jitter: 0.2}},
+		{"jitter turned off", task.RetryPolicy{Jitter: &zero},
+			retryPolicy{MaxAttempts: 5, Base: time.Second, Max: time.Hour, Jitter: 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.policy(tt.p); got != tt.want {
+				t.Fatalf("policy(%+v) = %+v, want %+v", tt.p, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Lister finds the objects stored under a bucket prefix such as
+// s3://bucket/videos/, so a schedule can submit each of them.
+type Lister interface {
+	List(ctx context.Context, prefix url.URL) ([]url.URL, error)
+	Lists(scheme string) bool
+}
+
+// BucketLister lists public S3 and Cloud Storage buckets over their
+// anonymous https APIs. Buckets that need credentials are not supported.
+type BucketLister struct {
+	Client *http.Client
+}
+
+// Lists implements the Lister interface
+func (bl BucketLister) Lists(scheme string) bool {
+	return scheme == "s3" || scheme == "gs"
+}
+
+// List implements the Lister interface for s3:// and gs:// prefixes
+func (bl BucketLister) List(ctx context.Context, prefix url.URL) ([]url.URL, error) {
+	bucket := prefix.Host
+	key := strings.TrimPrefix(prefix.Path, "/")
+
+	var keys []string
+	var err error
+	switch prefix.Scheme {
+	case "s3":
+		keys, err = bl.listS3(ctx, bucket, key)
+	case "gs":
+		keys, err = bl.listGS(ctx, bucket, key)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrNoLister, prefix.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make([]url.URL, 0, len(keys))
+	for _, k := range keys {
+		// zero byte "folder" markers are not objects to process
+		if strings.HasSuffix(k, "/") {
+			continue
+		}
+		objects = append(objects, url.URL{Scheme: prefix.Scheme, Host: bucket, Path: "/" + k})
+	}
+
+	return objects, nil
+}
+
+// listS3 pages through a ListObjectsV2 listing of the bucket
+func (bl BucketLister) listS3(ctx context.Context, bucket string, prefix string) ([]string, error) {
+	var keys []string
+	var token string
+	for {
+		q := url.Values{}
+		q.Set("list-type", "2")
+		q.Set("prefix", prefix)
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		u := url.URL{Scheme: "https", Host: bucket + ".s3.amazonaws.com", Path: "/", RawQuery: q.Encode()}
+
+		var page struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		if err := bl.get(ctx, u, func(r *http.Response) error {
+			return xml.NewDecoder(r.Body).Decode(&page)
+		}); err != nil {
+			return nil, fmt.Errorf("listing s3://%s/%s: %w", bucket, prefix, err)
+		}
+
+		for _, c := range page.Contents {
+			keys = append(keys, c.Key)
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// listGS pages through a JSON API listing of the bucket
+func (bl BucketLister) listGS(ctx context.Context, bucket string, prefix string) ([]string, error) {
+	var keys []string
+	var token string
+	for {
+		q := url.Values{}
+		q.Set("prefix", prefix)
+		q.Set("fields", "items/name,nextPageToken")
+		if token != "" {
+			q.Set("pageToken", token)
+		}
+		u := url.URL{Scheme: "https", Host: "storage.googleapis.com", Path: "/storage/v1/b/" + bucket + "/o", RawQuery: q.Encode()}
+
+		var page struct {
+			Items []struct {
+				Name string `json:"name"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := bl.get(ctx, u, func(r *http.Response) error {
+			return json.NewDecoder(r.Body).Decode(&page)
+		}); err != nil {
+			return nil, fmt.Errorf("listing gs://%s/%s: %w", bucket, prefix, err)
+		}
+
+		for _, item := range page.Items {
+			keys = append(keys, item.Name)
+		}
+		if page.NextPageToken == "" {
+			return keys, nil
+		}
+		token = page.NextPageToken
+	}
+}
+
+// get fetches u and hands a successful response to decode
+func (bl BucketLister) get(ctx context.Context, u url.URL, decode func(*http.Response) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := bl.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return decode(resp)
+}
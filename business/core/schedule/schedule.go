@@ -0,0 +1,359 @@
+// Package schedule provides the business logic for recurring task schedules
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	taskCore "github.com/jnkroeker/khyme/business/core/task"
+	"github.com/jnkroeker/khyme/business/data/store/schedule"
+	"github.com/jnkroeker/khyme/business/data/store/task"
+	"github.com/jnkroeker/khyme/business/sys/validate"
+	"github.com/jnkroeker/khyme/foundation/cron"
+	"go.uber.org/zap"
+)
+
+// Set of error variables
+var (
+	ErrNeverFires = errors.New("cron expression never fires")
+	ErrNoLister   = errors.New("bucket prefixes cannot be listed for this scheme")
+)
+
+type Core struct {
+	log      *zap.SugaredLogger
+	schedule schedule.Store
+	task     taskCore.Core
+	lister   Lister
+}
+
+func NewCore(log *zap.SugaredLogger, db *sqlx.DB, task taskCore.Core, lister Lister) Core {
+	return Core{
+		log:      log,
+		schedule: schedule.NewStore(log, db),
+		task:     task,
+		lister:   lister,
+	}
+}
+
+func (c Core) Create(ctx context.Context, ns schedule.NewSchedule, now time.Time) (schedule.Schedule, error) {
+
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	enabled := true
+	if ns.Enabled != nil {
+		enabled = *ns.Enabled
+	}
+
+	sch := schedule.Schedule{
+		ID:          validate.GenerateID(),
+		Name:        ns.Name,
+		Cron:        ns.Cron,
+		Resource:    ns.Resource,
+		Prefix:      ns.Prefix,
+		Template:    ns.Template,
+		Force:       ns.Force,
+		Enabled:     enabled,
+		DateCreated: now,
+		DateUpdated: now,
+	}
+
+	if _, err := c.prefix(sch); err != nil {
+		return schedule.Schedule{}, err
+	}
+
+	next, err := nextRun(sch, now)
+	if err != nil {
+		return schedule.Schedule{}, err
+	}
+	sch.NextRun = next
+
+	if err := c.schedule.Create(ctx, sch); err != nil {
+		return schedule.Schedule{}, fmt.Errorf("create: %w", err)
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	return sch, nil
+}
+
+// Update changes a schedule. Its next run is worked out again from now.
+func (c Core) Update(ctx context.Context, scheduleID string, us schedule.UpdateSchedule, now time.Time) (schedule.Schedule, error) {
+
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	if err := validate.CheckID(scheduleID); err != nil {
+		return schedule.Schedule{}, err
+	}
+
+	sch, err := c.schedule.QueryByID(ctx, scheduleID)
+	if err != nil {
+		return schedule.Schedule{}, fmt.Errorf("update: %w", err)
+	}
+
+	if us.Name != nil {
+		sch.Name = *us.Name
+	}
+	if us.Cron != nil {
+		sch.Cron = *us.Cron
+	}
+	if us.Resource != nil {
+		sch.Resource = *us.Resource
+	}
+	if us.Prefix != nil {
+		sch.Prefix = *us.Prefix
+	}
+	if us.Template != nil {
+		sch.Template = *us.Template
+	}
+	if us.Force != nil {
+		sch.Force = *us.Force
+	}
+	if us.Enabled != nil {
+		sch.Enabled = *us.Enabled
+	}
+	sch.DateUpdated = now
+
+	if _, err := c.prefix(sch); err != nil {
+		return schedule.Schedule{}, err
+	}
+
+	next, err := nextRun(sch, now)
+	if err != nil {
+		return schedule.Schedule{}, err
+	}
+	sch.NextRun = next
+
+	if err := c.schedule.Update(ctx, sch); err != nil {
+		return schedule.Schedule{}, fmt.Errorf("update: %w", err)
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	return sch, nil
+}
+
+func (c Core) Delete(ctx context.Context, scheduleID string) error {
+
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	if err := validate.CheckID(scheduleID); err != nil {
+		return err
+	}
+
+	if err := c.schedule.Delete(ctx, scheduleID); err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	return nil
+}
+
+func (c Core) Query(ctx context.Context, pageNumber int, rowsPerPage int) ([]schedule.Schedule, error) {
+
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	schs, err := c.schedule.Query(ctx, pageNumber, rowsPerPage)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	return schs, nil
+}
+
+func (c Core) QueryByID(ctx context.Context, scheduleID string) (schedule.Schedule, error) {
+
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	if err := validate.CheckID(scheduleID); err != nil {
+		return schedule.Schedule{}, err
+	}
+
+	sch, err := c.schedule.QueryByID(ctx, scheduleID)
+	if err != nil {
+		return schedule.Schedule{}, fmt.Errorf("query by id: %w", err)
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	return sch, nil
+}
+
+// Fire creates the tasks of every schedule that is due and moves each on to
+// its next run, returning how many tasks were created. A schedule whose
+// resources cannot be listed is logged and left due, so it is tried again
+// on the next call; a resource that cannot be templated is logged and
+// passed over. Runs missed while no tasker was up fire once, not once per
+// occurrence.
+func (c Core) Fire(ctx context.Context, now time.Time) (int, error) {
+	due, err := c.schedule.QueryDue(ctx, now)
+	if err != nil {
+		return 0, fmt.Errorf("fire: %w", err)
+	}
+
+	var created int
+	for _, sch := range due {
+		n, err := c.fire(ctx, sch, now)
+		if err != nil {
+			c.log.Errorw("scheduler", "status", "firing schedule", "schedule", sch.ID, "ERROR", err)
+			continue
+		}
+		created += n
+	}
+
+	return created, nil
+}
+
+// fire runs one occurrence of a schedule. Listing the bucket and templating
+// its objects reach out over the network, so they happen before anything is
+// locked. An advisory lock held for the length of the transaction that
+// stores the tasks means only one tasker replica fires the occurrence; the
+// others skip it, and find it no longer due once the lock is released.
+func (c Core) fire(ctx context.Context, sch schedule.Schedule, now time.Time) (int, error) {
+	resources, err := c.resources(ctx, sch)
+	if err != nil {
+		return 0, err
+	}
+
+	matches := make([]taskCore.Match, 0, len(resources))
+	for _, resource := range resources {
+		ntr := task.NewTaskRequest{
+			InputResource: resource,
+			Template:      sch.Template,
+			Labels:        task.Labels{"schedule": sch.ID},
+			Force:         sch.Force,
+		}
+
+		match, err := c.task.DryRun(ctx, ntr, now)
+		if err != nil {
+			// objects in a bucket that no template handles are expected
+			if !sch.Prefix || validate.Cause(err) != taskCore.ErrNoTemplate {
+				c.log.Errorw("scheduler", "status", "skipping resource", "schedule", sch.ID, "resource", resource, "ERROR", err)
+			}
+			continue
+		}
+		matches = append(matches, match)
+	}
+
+	var created int
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.schedule.Tran(tx)
+
+		locked, err := store.Lock(ctx, sch.ID)
+		if err != nil || !locked {
+			return err
+		}
+
+		cur, err := store.QueryByID(ctx, sch.ID)
+		if err != nil {
+			return err
+		}
+
+		// another replica fired it, or it was changed, since it was found due
+		if !cur.Enabled || cur.NextRun == nil || cur.NextRun.After(now) || !cur.DateUpdated.Equal(sch.DateUpdated) {
+			return nil
+		}
+
+		// the tasks are created in the same transaction that moves the
+		// schedule on, so an occurrence never fires twice or half way
+		tasks := c.task.Tran(tx)
+		for _, match := range matches {
+			res, ok, err := tasks.CreateMatch(ctx, match, now)
+			if err != nil {
+				return fmt.Errorf("template[%s]: %w", match.Template, err)
+			}
+			if ok {
+				created += len(res)
+			}
+		}
+
+		next, err := nextRun(cur, now)
+		if err != nil {
+			return err
+		}
+		cur.LastRun = &now
+		cur.NextRun = next
+		cur.DateUpdated = now
+
+		return store.Update(ctx, cur)
+	}
+
+	if err := c.schedule.WithinTran(ctx, tran); err != nil {
+		return 0, err
+	}
+
+	if created > 0 {
+		c.log.Infow("scheduler", "status", "schedule fired", "schedule", sch.ID, "created", created)
+	}
+
+	return created, nil
+}
+
+// resources returns the urls a schedule submits: its resource, or every
+// object under it when it is a bucket prefix.
+func (c Core) resources(ctx context.Context, sch schedule.Schedule) ([]string, error) {
+	if !sch.Prefix {
+		return []string{sch.Resource}, nil
+	}
+
+	prefix, err := c.prefix(sch)
+	if err != nil {
+		return nil, err
+	}
+
+	objects, err := c.lister.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make([]string, len(objects))
+	for i, o := range objects {
+		resources[i] = o.String()
+	}
+
+	return resources, nil
+}
+
+// prefix parses the bucket prefix of a schedule and checks it can be
+// listed, so a schedule that could never fire is refused when it is saved.
+func (c Core) prefix(sch schedule.Schedule) (url.URL, error) {
+	if !sch.Prefix {
+		return url.URL{}, nil
+	}
+
+	prefix, err := url.Parse(sch.Resource)
+	if err != nil || prefix.Host == "" {
+		return url.URL{}, taskCore.ErrInvalidResource
+	}
+	if c.lister == nil || !c.lister.Lists(prefix.Scheme) {
+		return url.URL{}, fmt.Errorf("%w: %s", ErrNoLister, prefix.Scheme)
+	}
+
+	return *prefix, nil
+}
+
+// nextRun works out when a schedule next fires after now. A disabled
+// schedule has no next run.
+func nextRun(sch schedule.Schedule, now time.Time) (*time.Time, error) {
+	expr, err := cron.Parse(sch.Cron)
+	if err != nil {
+		return nil, err
+	}
+
+	next := expr.Next(now)
+	if next.IsZero() {
+		return nil, ErrNeverFires
+	}
+
+	if !sch.Enabled {
+		return nil, nil
+	}
+
+	return &next, nil
+}
@@ -0,0 +1,201 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jnkroeker/khyme/business/data/store/event"
+	"github.com/jnkroeker/khyme/business/data/store/task"
+	"github.com/jnkroeker/khyme/business/sys/validate"
+)
+
+// Set of errors returned when a DAG submission is not well formed
+var (
+	ErrDuplicateKey = errors.New("dag node keys must be unique")
+	ErrCycle        = errors.New("dag dependencies form a cycle")
+)
+
+// CreateDAG templates every node of the submission and stores the
+// resulting tasks in one transaction, parents before the tasks that depend
+// on them. A node whose depends_on names another node's key waits on all
+// the tasks that node creates.
+func (c Core) CreateDAG(ctx context.Context, nd task.NewDAG, now time.Time) (task.DAG, error) {
+
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	order, err := topoSort(nd.Nodes)
+	if err != nil {
+		return task.DAG{}, err
+	}
+
+	// template everything before storing anything, so a bad node stores nothing
+	matches := make(map[string]Match, len(nd.Nodes))
+	for _, n := range nd.Nodes {
+		match, err := c.template(ctx, n.Task, now)
+		if err != nil {
+			return task.DAG{}, fmt.Errorf("node[%s]: %w", n.Key, err)
+		}
+		matches[n.Key] = match
+	}
+
+	var dag task.DAG
+	tran := func(tx sqlx.ExtContext) error {
+		core := c.Tran(tx)
+
+		dag = task.DAG{
+			Keys: make(map[string][]string, len(order)),
+		}
+		for _, n := range order {
+			var dependsOn []string
+			for _, dep := range n.Task.DependsOn {
+				if ids, ok := dag.Keys[dep]; ok {
+					dependsOn = append(dependsOn, ids...)
+					continue
+				}
+				dependsOn = append(dependsOn, dep)
+			}
+
+			res, _, err := core.create(ctx, matches[n.Key], dependsOn, event.Caller(ctx), now)
+			if err != nil {
+				return fmt.Errorf("node[%s]: %w", n.Key, err)
+			}
+
+			for _, t := range res {
+				dag.Keys[n.Key] = append(dag.Keys[n.Key], t.ID)
+			}
+			dag.Tasks = append(dag.Tasks, res...)
+		}
+
+		return nil
+	}
+
+	if err := retryDuplicate(func() error { return c.task.WithinTran(ctx, tran) }); err != nil {
+		return task.DAG{}, fmt.Errorf("create dag: %w", err)
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	return dag, nil
+}
+
+// topoSort orders the nodes so each comes after the nodes it depends on.
+// Dependencies that are not node keys name existing tasks and place no
+// constraint on the order.
+func topoSort(nodes []task.DAGNode) ([]task.DAGNode, error) {
+	byKey := make(map[string]task.DAGNode, len(nodes))
+	for _, n := range nodes {
+		if _, ok := byKey[n.Key]; ok {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateKey, n.Key)
+		}
+		byKey[n.Key] = n
+	}
+
+	waiting := make(map[string]int, len(nodes))
+	children := make(map[string][]string, len(nodes))
+	for _, n := range nodes {
+		seen := make(map[string]bool)
+		for _, dep := range n.Task.DependsOn {
+			if _, ok := byKey[dep]; !ok || seen[dep] {
+				continue
+			}
+			seen[dep] = true
+			waiting[n.Key]++
+			children[dep] = append(children[dep], n.Key)
+		}
+	}
+
+	// start from the nodes with no parents, in submission order
+	var ready []string
+	for _, n := range nodes {
+		if waiting[n.Key] == 0 {
+			ready = append(ready, n.Key)
+		}
+	}
+
+	order := make([]task.DAGNode, 0, len(nodes))
+	for len(ready) > 0 {
+		key := ready[0]
+		ready = ready[1:]
+		order = append(order, byKey[key])
+
+		for _, child := range children[key] {
+			if waiting[child]--; waiting[child] == 0 {
+				ready = append(ready, child)
+			}
+		}
+	}
+
+	// whatever never became ready waits on itself through a cycle
+	if len(order) < len(nodes) {
+		var stuck []string
+		for key, n := range waiting {
+			if n > 0 {
+				stuck = append(stuck, key)
+			}
+		}
+		sort.Strings(stuck)
+		return nil, fmt.Errorf("%w: %v", ErrCycle, stuck)
+	}
+
+	return order, nil
+}
+
+// QueryGraph returns the dependency graph the task belongs to
+func (c Core) QueryGraph(ctx context.Context, taskID string) (task.Graph, error) {
+
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	if err := validate.CheckID(taskID); err != nil {
+		return task.Graph{}, err
+	}
+
+	if _, err := c.task.QueryByID(ctx, taskID); err != nil {
+		return task.Graph{}, fmt.Errorf("query graph: %w", err)
+	}
+
+	edges, err := c.task.QueryGraph(ctx, taskID)
+	if err != nil {
+		return task.Graph{}, fmt.Errorf("query graph: %w", err)
+	}
+
+	ids := []string{taskID}
+	seen := map[string]bool{taskID: true}
+	for _, e := range edges {
+		for _, id := range []string{e.TaskID, e.DependsOn} {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	tasks, err := c.task.QueryByIDs(ctx, ids)
+	if err != nil {
+		return task.Graph{}, fmt.Errorf("query graph: %w", err)
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	g := task.Graph{
+		TaskID: taskID,
+		Nodes:  make([]task.GraphNode, len(tasks)),
+		Edges:  edges,
+	}
+	for i, t := range tasks {
+		g.Nodes[i] = task.GraphNode{
+			ID:            t.ID,
+			Status:        t.Status,
+			Template:      t.Template,
+			InputResource: t.InputResource,
+		}
+	}
+	if g.Edges == nil {
+		g.Edges = []task.Dependency{}
+	}
+
+	return g, nil
+}
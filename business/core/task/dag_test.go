@@ -0,0 +1,57 @@
+package task
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/jnkroeker/khyme/business/data/store/task"
+)
+
+func TestTopoSort(t *testing.T) {
+	node := func(key string, dependsOn ...string) task.DAGNode {
+		return task.DAGNode{Key: key, Task: task.NewTaskRequest{DependsOn: dependsOn}}
+	}
+
+	const existing = "5cf37266-3473-4006-984f-9325122678b7"
+
+	tests := []struct {
+		name  string
+		nodes []task.DAGNode
+		want  []string
+		err   error
+	}{
+		{"no nodes", nil, []string{}, nil},
+		{"independent nodes keep their order", []task.DAGNode{node("a"), node("b"), node("c")}, []string{"a", "b", "c"}, nil},
+		{"chain submitted backwards", []task.DAGNode{node("c", "b"), node("b", "a"), node("a")}, []string{"a", "b", "c"}, nil},
+		{"diamond", []task.DAGNode{node("d", "b", "c"), node("b", "a"), node("c", "a"), node("a")}, []string{"a", "b", "c", "d"}, nil},
+		{"repeated dependency", []task.DAGNode{node("b", "a", "a"), node("a")}, []string{"a", "b"}, nil},
+		{"existing task", []task.DAGNode{node("a", existing), node("b", "a")}, []string{"a", "b"}, nil},
+		{"duplicate key", []task.DAGNode{node("a"), node("a")}, nil, ErrDuplicateKey},
+		{"self cycle", []task.DAGNode{node("a", "a")}, nil, ErrCycle},
+		{"cycle", []task.DAGNode{node("a", "c"), node("b", "a"), node("c", "b"), node("d")}, nil, ErrCycle},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, err := topoSort(tt.nodes)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("topoSort returned %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("topoSort returned %v", err)
+			}
+
+			got := make([]string, len(order))
+			for i, n := range order {
+				got[i] = n.Key
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("topoSort order = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
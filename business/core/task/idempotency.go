@@ -0,0 +1,160 @@
+package task
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jnkroeker/khyme/business/data/store/event"
+	"github.com/jnkroeker/khyme/business/data/store/task"
+	"github.com/jnkroeker/khyme/business/sys/database"
+)
+
+// MaxIdempotencyKey is the longest Idempotency-Key a caller may send
+const MaxIdempotencyKey = 255
+
+// Set of error variables for idempotent submission
+var (
+	ErrInvalidIdempotencyKey = errors.New("idempotency key must be 1 to 255 characters")
+	ErrIdempotencyConflict   = errors.New("idempotency key was already used with a different submission")
+)
+
+// CreateOnce is Create for a submission carrying an idempotency key. Repeating
+// the submission with the same key inside the idempotency window returns the
+// tasks it first created and reports created as false. Reusing the key for a
+// different submission returns ErrIdempotencyConflict. A replay is answered
+// from the key alone, so it returns the original tasks even when templating
+// the submission again would now fail.
+func (c Core) CreateOnce(ctx context.Context, key string, ntr task.NewTaskRequest, now time.Time) ([]task.Task, bool, error) {
+
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	if key == "" || len(key) > MaxIdempotencyKey {
+		return nil, false, ErrInvalidIdempotencyKey
+	}
+
+	hash, err := requestHash(ntr)
+	if err != nil {
+		return nil, false, fmt.Errorf("hashing submission: %w", err)
+	}
+
+	expiredBefore := now.Add(-c.cfg.IdempotencyWindow)
+
+	k, err := c.idempotency.QueryByKey(ctx, key)
+	switch {
+	case err == nil && !k.DateCreated.Before(expiredBefore):
+		if k.RequestHash != hash || k.TaskID == nil {
+			return nil, false, ErrIdempotencyConflict
+		}
+		res, created, err := c.replay(ctx, key, *k.TaskID)
+		if !errors.Is(err, database.ErrNotFound) {
+			return res, created, err
+		}
+	case err != nil && !errors.Is(err, database.ErrNotFound):
+		return nil, false, fmt.Errorf("create once: %w", err)
+	}
+
+	// the key is new, expired or lost its task, so this is a submission in its own right
+	match, err := c.template(ctx, ntr, now)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var res []task.Task
+	var created bool
+	var originalID string
+	tran := func(tx sqlx.ExtContext) error {
+		keys := c.idempotency.Tran(tx)
+
+		reserved, err := keys.Reserve(ctx, key, hash, now, expiredBefore)
+		if err != nil {
+			return err
+		}
+
+		// a concurrent submission took the key since it was looked up
+		if !reserved {
+			k, err := keys.QueryByKey(ctx, key)
+			if err != nil {
+				return err
+			}
+			if k.RequestHash != hash || k.TaskID == nil {
+				return ErrIdempotencyConflict
+			}
+			originalID = *k.TaskID
+			return nil
+		}
+
+		res, created, err = c.Tran(tx).create(ctx, match, ntr.DependsOn, event.Caller(ctx), now)
+		if err != nil {
+			return err
+		}
+		return keys.SetTask(ctx, key, res[0].ID)
+	}
+
+	if err := retryDuplicate(func() error { return c.idempotency.WithinTran(ctx, tran) }); err != nil {
+		return nil, false, fmt.Errorf("create once: %w", err)
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	// a dedup hit answers with the live task for the resource, not a new one
+	if originalID == "" {
+		return res, created, nil
+	}
+
+	return c.replay(ctx, key, originalID)
+}
+
+// replay returns the tasks the submission first made with key created
+func (c Core) replay(ctx context.Context, key string, taskID string) ([]task.Task, bool, error) {
+	res, err := original(ctx, c.task, taskID)
+	if err != nil {
+		return nil, false, fmt.Errorf("create once: %w", err)
+	}
+
+	c.log.Infow("create once", "status", "replayed submission", "key", key, "task", taskID)
+
+	return res, false, nil
+}
+
+// PurgeIdempotencyKeys forgets the keys older than the idempotency window
+func (c Core) PurgeIdempotencyKeys(ctx context.Context, now time.Time) (int, error) {
+	n, err := c.idempotency.Purge(ctx, now.Add(-c.cfg.IdempotencyWindow))
+	if err != nil {
+		return 0, fmt.Errorf("purge idempotency keys: %w", err)
+	}
+
+	return n, nil
+}
+
+// original loads the tasks a submission created from the first of them,
+// bringing back the whole group when the submission fanned out.
+func original(ctx context.Context, store task.Store, taskID string) ([]task.Task, error) {
+	t, err := store.QueryByID(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.GroupID == nil {
+		return []task.Task{t}, nil
+	}
+
+	return store.QueryGroup(ctx, *t.GroupID)
+}
+
+// requestHash fingerprints a submission. Decoding and re-encoding the body
+// means the same submission hashes the same however its json was laid out.
+func requestHash(ntr task.NewTaskRequest) (string, error) {
+	b, err := json.Marshal(ntr)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
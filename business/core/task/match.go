@@ -0,0 +1,263 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MatchConfig declares the rule a resource must satisfy for a Template to
+// apply. Every rule set in one MatchConfig must hold. All, Any and Not
+// nest further MatchConfigs to build and/or/not logic.
+//
+// Host, Path, Query values and ContentType are globs in path.Match syntax.
+// Regex is matched against the whole resource url.
+type MatchConfig struct {
+	Ext         string            `json:"ext,omitempty"`
+	Scheme      string            `json:"scheme,omitempty"`
+	Host        string            `json:"host,omitempty"`
+	Path        string            `json:"path,omitempty"`
+	Regex       string            `json:"regex,omitempty"`
+	Query       map[string]string `json:"query,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	All         []MatchConfig     `json:"all,omitempty"`
+	Any         []MatchConfig     `json:"any,omitempty"`
+	Not         *MatchConfig      `json:"not,omitempty"`
+}
+
+// ContentTypeResolver looks up the content type of a resource for templates
+// that match on it. It is only called when such a template is evaluated.
+type ContentTypeResolver interface {
+	ContentType(ctx context.Context, resource url.URL) (string, error)
+}
+
+// matcher decides whether a resource satisfies a rule. The reason explains
+// the outcome either way, so a misrouted resource can be diagnosed.
+type matcher interface {
+	match(resource url.URL, p *probe) (ok bool, reason string)
+}
+
+// probe resolves the content type of the resource being matched at most once
+type probe struct {
+	ctx      context.Context
+	resolver ContentTypeResolver
+	resource url.URL
+	resolved bool
+	ct       string
+	err      error
+}
+
+func (p *probe) contentType() (string, error) {
+	if !p.resolved {
+		p.resolved = true
+		if p.resolver == nil {
+			p.err = errors.New("no content type resolver configured")
+		} else {
+			p.ct, p.err = p.resolver.ContentType(p.ctx, p.resource)
+		}
+	}
+	return p.ct, p.err
+}
+
+// compileMatch turns a rule declaration into a matcher
+func compileMatch(mc MatchConfig) (matcher, error) {
+	var all allOf
+
+	if mc.Ext != "" {
+		all = append(all, condition("ext", mc.Ext, func(r url.URL, _ *probe) (string, bool, error) {
+			ext := path.Ext(r.Path)
+			return ext, strings.EqualFold(ext, mc.Ext), nil
+		}))
+	}
+
+	if mc.Scheme != "" {
+		all = append(all, condition("scheme", mc.Scheme, func(r url.URL, _ *probe) (string, bool, error) {
+			return r.Scheme, strings.EqualFold(r.Scheme, mc.Scheme), nil
+		}))
+	}
+
+	if mc.Host != "" {
+		if err := checkGlob(mc.Host); err != nil {
+			return nil, fmt.Errorf("host: %w", err)
+		}
+		all = append(all, condition("host", mc.Host, func(r url.URL, _ *probe) (string, bool, error) {
+			ok, _ := path.Match(mc.Host, r.Host)
+			return r.Host, ok, nil
+		}))
+	}
+
+	if mc.Path != "" {
+		if err := checkGlob(mc.Path); err != nil {
+			return nil, fmt.Errorf("path: %w", err)
+		}
+		all = append(all, condition("path", mc.Path, func(r url.URL, _ *probe) (string, bool, error) {
+			ok, _ := path.Match(mc.Path, r.Path)
+			return r.Path, ok, nil
+		}))
+	}
+
+	if mc.Regex != "" {
+		re, err := regexp.Compile(mc.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("regex: %w", err)
+		}
+		all = append(all, condition("url", mc.Regex, func(r url.URL, _ *probe) (string, bool, error) {
+			s := r.String()
+			return s, re.MatchString(s), nil
+		}))
+	}
+
+	// sort the keys so reasons read the same on every run
+	keys := make([]string, 0, len(mc.Query))
+	for key := range mc.Query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		key, glob := key, mc.Query[key]
+		if err := checkGlob(glob); err != nil {
+			return nil, fmt.Errorf("query %s: %w", key, err)
+		}
+		all = append(all, condition("query "+key, glob, func(r url.URL, _ *probe) (string, bool, error) {
+			values, ok := r.Query()[key]
+			if !ok {
+				return "", false, errors.New("parameter missing")
+			}
+			for _, v := range values {
+				if ok, _ := path.Match(glob, v); ok {
+					return v, true, nil
+				}
+			}
+			return strings.Join(values, ","), false, nil
+		}))
+	}
+
+	if mc.ContentType != "" {
+		if err := checkGlob(mc.ContentType); err != nil {
+			return nil, fmt.Errorf("content_type: %w", err)
+		}
+		all = append(all, condition("content_type", mc.ContentType, func(r url.URL, p *probe) (string, bool, error) {
+			ct, err := p.contentType()
+			if err != nil {
+				return "", false, err
+			}
+			ok, _ := path.Match(mc.ContentType, ct)
+			return ct, ok, nil
+		}))
+	}
+
+	for i, sub := range mc.All {
+		m, err := compileMatch(sub)
+		if err != nil {
+			return nil, fmt.Errorf("all[%d]: %w", i, err)
+		}
+		all = append(all, m)
+	}
+
+	if len(mc.Any) > 0 {
+		var either anyOf
+		for i, sub := range mc.Any {
+			m, err := compileMatch(sub)
+			if err != nil {
+				return nil, fmt.Errorf("any[%d]: %w", i, err)
+			}
+			either = append(either, m)
+		}
+		all = append(all, either)
+	}
+
+	if mc.Not != nil {
+		m, err := compileMatch(*mc.Not)
+		if err != nil {
+			return nil, fmt.Errorf("not: %w", err)
+		}
+		all = append(all, not{m})
+	}
+
+	if len(all) == 0 {
+		return nil, errors.New("match needs at least one rule")
+	}
+
+	if len(all) == 1 {
+		return all[0], nil
+	}
+
+	return all, nil
+}
+
+// checkGlob reports a malformed glob when the template is loaded
+// rather than silently never matching.
+func checkGlob(glob string) error {
+	_, err := path.Match(glob, "")
+	return err
+}
+
+// =============================================================================
+
+// test reports the value of the resource it looked at and whether it matched
+type test func(resource url.URL, p *probe) (value string, ok bool, err error)
+
+type cond struct {
+	field string
+	want  string
+	test  test
+}
+
+func condition(field string, want string, t test) cond {
+	return cond{field: field, want: want, test: t}
+}
+
+func (c cond) match(resource url.URL, p *probe) (bool, string) {
+	value, ok, err := c.test(resource, p)
+	switch {
+	case err != nil:
+		return false, fmt.Sprintf("%s %q: %v", c.field, c.want, err)
+	case ok:
+		return true, fmt.Sprintf("%s %q matches %q", c.field, value, c.want)
+	default:
+		return false, fmt.Sprintf("%s %q does not match %q", c.field, value, c.want)
+	}
+}
+
+type allOf []matcher
+
+func (a allOf) match(resource url.URL, p *probe) (bool, string) {
+	reasons := make([]string, 0, len(a))
+	for _, m := range a {
+		ok, reason := m.match(resource, p)
+		if !ok {
+			return false, reason
+		}
+		reasons = append(reasons, reason)
+	}
+	return true, strings.Join(reasons, " and ")
+}
+
+type anyOf []matcher
+
+func (a anyOf) match(resource url.URL, p *probe) (bool, string) {
+	reasons := make([]string, 0, len(a))
+	for _, m := range a {
+		ok, reason := m.match(resource, p)
+		if ok {
+			return true, "(" + reason + ")"
+		}
+		reasons = append(reasons, reason)
+	}
+	return false, "(" + strings.Join(reasons, " or ") + ")"
+}
+
+type not struct {
+	m matcher
+}
+
+func (n not) match(resource url.URL, p *probe) (bool, string) {
+	ok, reason := n.m.match(resource, p)
+	return !ok, "not (" + reason + ")"
+}
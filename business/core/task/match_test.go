@@ -0,0 +1,116 @@
+package task
+
+import (
+	"context"
+	"net/url"
+	"testing"
+)
+
+// fakeResolver answers every probe with the same content type and counts the calls
+type fakeResolver struct {
+	ct    string
+	calls *int
+}
+
+func (f fakeResolver) ContentType(ctx context.Context, resource url.URL) (string, error) {
+	*f.calls++
+	return f.ct, nil
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		mc       MatchConfig
+		resource string
+		want     bool
+		reason   string
+	}{
+		{"ext", MatchConfig{Ext: ".mp4"}, "s3://b/a.MP4", true, `ext ".MP4" matches ".mp4"`},
+		{"ext mismatch", MatchConfig{Ext: ".mp4"}, "s3://b/a.mov", false, `ext ".mov" does not match ".mp4"`},
+		{"scheme", MatchConfig{Scheme: "s3"}, "S3://b/a.mp4", true, ""},
+		{"host glob", MatchConfig{Host: "*.example.com"}, "https://cdn.example.com/a", true, ""},
+		{"path glob", MatchConfig{Path: "/raw/*"}, "s3://b/raw/a.mp4", true, ""},
+		{"path glob stops at slash", MatchConfig{Path: "/raw/*"}, "s3://b/raw/x/a.mp4", false, ""},
+		{"regex", MatchConfig{Regex: `^s3://b/.*\.mp4$`}, "s3://b/x/a.mp4", true, ""},
+		{"query", MatchConfig{Query: map[string]string{"v": "2*"}}, "https://h/a?v=21", true, ""},
+		{"query missing", MatchConfig{Query: map[string]string{"v": "*"}}, "https://h/a", false, `query v "*": parameter missing`},
+		{"content type", MatchConfig{ContentType: "video/*"}, "https://h/a", true, `content_type "video/mp4" matches "video/*"`},
+		{"every rule holds", MatchConfig{Scheme: "s3", Ext: ".mp4"}, "s3://b/a.mp4", true,
+			`ext ".mp4" matches ".mp4" and scheme "s3" matches "s3"`},
+		{"one rule fails", MatchConfig{Scheme: "gs", Ext: ".mp4"}, "s3://b/a.mp4", false, `scheme "s3" does not match "gs"`},
+		{"any", MatchConfig{Any: []MatchConfig{{Ext: ".mov"}, {Ext: ".mp4"}}}, "s3://b/a.mp4", true, `(ext ".mp4" matches ".mp4")`},
+		{"any none", MatchConfig{Any: []MatchConfig{{Ext: ".mov"}, {Ext: ".avi"}}}, "s3://b/a.mp4", false,
+			`(ext ".mp4" does not match ".mov" or ext ".mp4" does not match ".avi")`},
+		{"not", MatchConfig{Ext: ".mp4", Not: &MatchConfig{Path: "/tmp/*"}}, "s3://b/tmp/a.mp4", false,
+			`not (path "/tmp/a.mp4" matches "/tmp/*")`},
+		{"all nested", MatchConfig{All: []MatchConfig{{Scheme: "s3"}, {Any: []MatchConfig{{Ext: ".mp4"}}}}}, "s3://b/a.mp4", true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := compileMatch(tt.mc)
+			if err != nil {
+				t.Fatalf("compileMatch returned %v", err)
+			}
+
+			u, err := url.Parse(tt.resource)
+			if err != nil {
+				t.Fatalf("parsing %q: %v", tt.resource, err)
+			}
+
+			var calls int
+			p := probe{ctx: context.Background(), resolver: fakeResolver{ct: "video/mp4", calls: &calls}, resource: *u}
+
+			ok, reason := m.match(*u, &p)
+			if ok != tt.want {
+				t.Fatalf("match(%q) = %t (%s), want %t", tt.resource, ok, reason, tt.want)
+			}
+			if tt.reason != "" && reason != tt.reason {
+				t.Fatalf("match(%q) reason = %q, want %q", tt.resource, reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestMatchProbesOnce(t *testing.T) {
+	mc := MatchConfig{Any: []MatchConfig{{ContentType: "image/*"}, {ContentType: "video/*"}}}
+	m, err := compileMatch(mc)
+	if err != nil {
+		t.Fatalf("compileMatch returned %v", err)
+	}
+
+	u, _ := url.Parse("https://h/a")
+	var calls int
+	p := probe{ctx: context.Background(), resolver: fakeResolver{ct: "video/mp4", calls: &calls}, resource: *u}
+
+	if ok, reason := m.match(*u, &p); !ok {
+		t.Fatalf("match = false (%s), want true", reason)
+	}
+	if calls != 1 {
+		t.Fatalf("content type resolved %d times, want 1", calls)
+	}
+}
+
+func TestCompileMatch(t *testing.T) {
+	tests := []struct {
+		name string
+		mc   MatchConfig
+	}{
+		{"no rules", MatchConfig{}},
+		{"bad host glob", MatchConfig{Host: "["}},
+		{"bad path glob", MatchConfig{Path: "/a/["}},
+		{"bad regex", MatchConfig{Regex: "("}},
+		{"bad query glob", MatchConfig{Query: map[string]string{"v": "["}}},
+		{"bad content type glob", MatchConfig{ContentType: "["}},
+		{"bad nested rule", MatchConfig{Any: []MatchConfig{{Ext: ".mp4"}, {}}}},
+		{"empty not", MatchConfig{Ext: ".mp4", Not: &MatchConfig{}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := compileMatch(tt.mc); err == nil {
+				t.Fatal("compileMatch returned no error")
+			}
+		})
+	}
+}
@@ -1,56 +1,166 @@
 package task
 
 import (
+	"context"
 	"net/url"
-	"os"
-	"path"
-	"strings"
-	"time"
 
 	"github.com/jnkroeker/khyme/business/data/store/task"
 )
 
+// Template turns a resource it matches into tasks. Templates are
+// declared in the templates file and built with NewTemplate.
 type Template struct {
 	Name   string
-	Create func(resource url.URL) *task.Task
+	FanOut bool
+	Create func(resource url.URL) []task.NewTask
+	match  matcher
+	rule   MatchConfig
+	specs  []TaskConfig
+}
+
+// TemplateInfo describes a registered Template for the catalogue
+type TemplateInfo struct {
+	Name    string       `json:"name"`
+	Version string       `json:"version"`
+	Match   MatchConfig  `json:"match"`
+	FanOut  bool         `json:"fan_out"`
+	Tasks   []TaskConfig `json:"tasks"`
+}
+
+// Match is the outcome of templating a resource: the template that matched,
+// why it matched, and the tasks it produced.
+type Match struct {
+	Template string         `json:"template"`
+	Reason   string         `json:"reason"`
+	FanOut   bool           `json:"fan_out"`
+	Tasks    []task.NewTask `json:"tasks"`
+}
+
+// Pipeline chains templates into stages. When the task of a stage succeeds
+// its output is submitted to the template of the next stage.
+type Pipeline struct {
+	Name   string   `json:"name"`
+	Stages []string `json:"stages"`
 }
 
 type Templater struct {
 	templates []Template
+	pipelines []Pipeline
 	version   string
+	resolver  ContentTypeResolver
 }
 
 func NewTemplater(templates []Template, version string) Templater {
-	return Templater{templates, version}
+	return Templater{templates: templates, version: version}
+}
+
+// WithResolver returns a copy of the Templater that looks up content types
+// with r for templates that match on them.
+func (t Templater) WithResolver(r ContentTypeResolver) Templater {
+	t.resolver = r
+	return t
+}
+
+// WithPipelines returns a copy of the Templater that also holds pipelines
+func (t Templater) WithPipelines(pipelines []Pipeline) Templater {
+	t.pipelines = pipelines
+	return t
+}
+
+// Pipelines returns every registered pipeline
+func (t Templater) Pipelines() []Pipeline {
+	return t.pipelines
+}
+
+// Pipeline returns the named pipeline, and false when there is none
+func (t Templater) Pipeline(name string) (Pipeline, bool) {
+	for _, p := range t.pipelines {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Pipeline{}, false
+}
+
+// Templates describes every registered template, in the order they are tried
+func (t Templater) Templates() []TemplateInfo {
+	infos := make([]TemplateInfo, 0, len(t.templates))
+	for _, template := range t.templates {
+		infos = append(infos, TemplateInfo{
+			Name:    template.Name,
+			Version: t.version,
+			Match:   template.rule,
+			FanOut:  template.FanOut,
+			Tasks:   template.specs,
+		})
+	}
+	return infos
 }
 
-func (t Templater) Create(resource url.URL) *task.Task {
+// Use builds the tasks for a resource from the named template, skipping
+// the match rules. It returns nil when no template has that name.
+func (t Templater) Use(ctx context.Context, name string, resource url.URL) *Match {
 	for _, template := range t.templates {
-		if task := template.Create(resource); task != nil {
-			task.Version = t.version
-			return task
+		if template.Name != name {
+			continue
 		}
+		return t.match(template, resource, "template requested by name")
 	}
 	return nil
 }
 
-var Mp4 = &Template{
-	Name: "Mp4",
-	Create: func(resource url.URL) *task.Task {
-		if strings.ToLower(path.Ext(resource.Path)) != ".mp4" {
-			return nil
+// Create builds the tasks for a resource from the first template that matches it,
+// recording on each task which template matched and why.
+// It returns nil when no template matches.
+func (t Templater) Create(ctx context.Context, resource url.URL) *Match {
+	p := probe{
+		ctx:      ctx,
+		resolver: t.resolver,
+		resource: resource,
+	}
+
+	for _, template := range t.templates {
+		ok, reason := template.match.match(resource, &p)
+		if !ok {
+			continue
 		}
 
-		outUrl := resource
-		outUrl.Path = path.Join(os.Getenv("CH_TEMPLATE_MP4_MIRROR_PREFIX"), outUrl.Host, outUrl.Path) + "/"
-		outUrl.Host = os.Getenv("CH_TEMPLATE_MP4_MIRROR_BUCKET")
+		return t.match(template, resource, reason)
+	}
+	return nil
+}
 
-		return &task.Task{
-			InputResource:  resource.String(),
-			OutputResource: outUrl.String(),
-			Hooks:          "mp4",
-			ExecutionImage: "jnkroeker/mp4_processor:0.1.4",
-			Timeout:        time.Duration(48) * time.Hour,
-		}
-	},
+// match stamps the tasks a template produced with the build version and
+// the reason the template was chosen.
+func (t Templater) match(template Template, resource url.URL, reason string) *Match {
+	tasks := template.Create(resource)
+	for i := range tasks {
+		tasks[i].Version = t.version
+		tasks[i].MatchReason = reason
+	}
+
+	m := Match{
+		Template: template.Name,
+		Reason:   reason,
+		FanOut:   template.FanOut,
+		Tasks:    tasks,
+	}
+	return &m
+}
+
+// overrideRetry returns the policy p with every field set in o replacing its own
+func overrideRetry(p task.RetryPolicy, o task.RetryPolicy) task.RetryPolicy {
+	if o.MaxAttempts != nil {
+		p.MaxAttempts = o.MaxAttempts
+	}
+	if o.Base != nil {
+		p.Base = o.Base
+	}
+	if o.Max != nil {
+		p.Max = o.Max
+	}
+	if o.Jitter != nil {
+		p.Jitter = o.Jitter
+	}
+	return p
 }
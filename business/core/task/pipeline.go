@@ -0,0 +1,234 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jnkroeker/khyme/business/data/store/event"
+	"github.com/jnkroeker/khyme/business/data/store/pipeline"
+	"github.com/jnkroeker/khyme/business/data/store/task"
+	"github.com/jnkroeker/khyme/business/sys/database"
+	"github.com/jnkroeker/khyme/business/sys/validate"
+)
+
+// ErrUnknownPipeline is returned when a pipeline name is not registered
+var ErrUnknownPipeline = errors.New("pipeline is not registered")
+
+// Pipelines returns the registered pipelines
+func (c Core) Pipelines() []Pipeline {
+	return c.templater.Pipelines()
+}
+
+// StartPipeline begins a run of the named pipeline, creating the task of
+// its first stage for the submitted resource.
+func (c Core) StartPipeline(ctx context.Context, name string, nr pipeline.NewRun, now time.Time) (pipeline.Run, error) {
+
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	p, ok := c.templater.Pipeline(name)
+	if !ok {
+		return pipeline.Run{}, ErrUnknownPipeline
+	}
+
+	run := pipeline.Run{
+		ID:            validate.GenerateID(),
+		Pipeline:      p.Name,
+		InputResource: nr.InputResource,
+		Labels:        nr.Labels,
+		Status:        task.StatusRunning,
+		Tasks:         []string{},
+		DateCreated:   now,
+		DateUpdated:   now,
+	}
+
+	match, err := c.template(ctx, stageRequest(run, p.Stages[0], nr.InputResource), now)
+	if err != nil {
+		return pipeline.Run{}, err
+	}
+
+	tran := func(tx sqlx.ExtContext) error {
+		res, _, err := c.Tran(tx).create(ctx, match, nil, event.Caller(ctx), now)
+		if err != nil {
+			return err
+		}
+		run.TaskID = &res[0].ID
+		run.Tasks = append(run.Tasks, res[0].ID)
+
+		return c.pipeline.Tran(tx).Create(ctx, run)
+	}
+
+	if err := c.task.WithinTran(ctx, tran); err != nil {
+		return pipeline.Run{}, fmt.Errorf("start pipeline: %w", err)
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	return run, nil
+}
+
+// QueryRuns returns a page of the runs of the named pipeline, newest first
+func (c Core) QueryRuns(ctx context.Context, name string, pageNumber int, rowsPerPage int) ([]pipeline.Run, error) {
+
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	if _, ok := c.templater.Pipeline(name); !ok {
+		return nil, ErrUnknownPipeline
+	}
+
+	runs, err := c.pipeline.QueryByPipeline(ctx, name, pageNumber, rowsPerPage)
+	if err != nil {
+		return nil, fmt.Errorf("query runs: %w", err)
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	return runs, nil
+}
+
+func (c Core) QueryRun(ctx context.Context, runID string) (pipeline.Run, error) {
+
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	if err := validate.CheckID(runID); err != nil {
+		return pipeline.Run{}, err
+	}
+
+	run, err := c.pipeline.QueryByID(ctx, runID)
+	if err != nil {
+		return pipeline.Run{}, fmt.Errorf("query run: %w", err)
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	return run, nil
+}
+
+// stage is the next stage of a pipeline run, templated from the output of
+// the current one before the transaction that finishes it is begun
+type stage struct {
+	runID string
+	index int
+	match Match
+	err   error
+}
+
+// nextStage templates the stage after the one taskID is the current stage
+// of. It returns nil when the task is not the current stage of a run, or is
+// its last.
+func (c Core) nextStage(ctx context.Context, taskID string, now time.Time) (*stage, error) {
+	run, err := c.pipeline.QueryByTask(ctx, taskID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	p, ok := c.templater.Pipeline(run.Pipeline)
+	if !ok || run.Stage+1 >= len(p.Stages) {
+		return nil, nil
+	}
+
+	t, err := c.task.QueryByID(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	// an output the next template cannot take ends the run, not the status update
+	next := stage{
+		runID: run.ID,
+		index: run.Stage + 1,
+	}
+	next.match, next.err = c.template(ctx, stageRequest(run, p.Stages[next.index], t.OutputResource), now)
+
+	return &next, nil
+}
+
+// advance moves on the pipeline run whose current stage is t, if there is
+// one, once t has finished. A stage that succeeded is followed by next, the
+// templated next stage; a stage that failed or was cancelled ends the run.
+// The Core is expected to be bound to a transaction.
+func (c Core) advance(ctx context.Context, t task.Task, next *stage, now time.Time) error {
+	if !t.Status.IsTerminal() {
+		return nil
+	}
+
+	run, err := c.pipeline.QueryByTask(ctx, t.ID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if t.Status != task.StatusSucceeded {
+		return c.endRun(ctx, run, t.Status, fmt.Sprintf("stage %d %s", run.Stage, t.Status), now)
+	}
+
+	p, ok := c.templater.Pipeline(run.Pipeline)
+	if !ok {
+		return c.endRun(ctx, run, task.StatusFailed, ErrUnknownPipeline.Error(), now)
+	}
+
+	if run.Stage+1 >= len(p.Stages) {
+		run.Status = task.StatusSucceeded
+		run.DateUpdated = now
+		return c.pipeline.Update(ctx, run)
+	}
+
+	// the run is locked now, so it cannot have moved since next was templated
+	// unless another update of the same task won the race
+	if next == nil || next.runID != run.ID || next.index != run.Stage+1 {
+		return fmt.Errorf("stage %d of run %s was not templated", run.Stage+1, run.ID)
+	}
+	if next.err != nil {
+		return c.endRun(ctx, run, task.StatusFailed, fmt.Sprintf("stage %d: %s", next.index, next.err), now)
+	}
+
+	res, _, err := c.create(ctx, next.match, nil, event.ActorSystem, now)
+	if err != nil {
+		return err
+	}
+
+	run.Stage++
+	run.TaskID = &res[0].ID
+	run.Tasks = append(run.Tasks, res[0].ID)
+	run.DateUpdated = now
+
+	c.log.Infow("pipeline", "run", run.ID, "stage", run.Stage, "template", next.match.Template, "task", res[0].ID)
+
+	return c.pipeline.Update(ctx, run)
+}
+
+// endRun stops a run short of its last stage
+func (c Core) endRun(ctx context.Context, run pipeline.Run, status task.Status, reason string, now time.Time) error {
+	run.Status = status
+	run.LastError = &reason
+	run.DateUpdated = now
+
+	c.log.Infow("pipeline", "run", run.ID, "stage", run.Stage, "status", status, "reason", reason)
+
+	return c.pipeline.Update(ctx, run)
+}
+
+// stageRequest builds the submission for one stage of a run. Stages are
+// always forced: a run processes its resource afresh even when the same
+// output went through that template before.
+func stageRequest(run pipeline.Run, stage string, input string) task.NewTaskRequest {
+	labels := make(task.Labels, len(run.Labels)+2)
+	for k, v := range run.Labels {
+		labels[k] = v
+	}
+	labels["pipeline"] = run.Pipeline
+	labels["pipeline_run"] = run.ID
+
+	return task.NewTaskRequest{
+		InputResource: input,
+		Template:      stage,
+		Labels:        labels,
+		Force:         true,
+	}
+}
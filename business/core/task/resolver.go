@@ -0,0 +1,151 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrProbeRefused is returned for a resource the resolver may not reach.
+// Probes run from inside the cluster, so they never reach private or
+// loopback addresses, and only the configured hosts when there are any.
+var ErrProbeRefused = errors.New("resource may not be probed")
+
+// HTTPResolver resolves the content type of http and https resources
+// by asking the server for their headers. Hosts, when set, lists the
+// only hosts that are probed; an entry starting with a dot also allows
+// every subdomain of it.
+type HTTPResolver struct {
+	Client *http.Client
+	Hosts  []string
+}
+
+// NewHTTPResolver returns an HTTPResolver whose probes time out after
+// timeout, follow redirects only to allowed hosts and dial public
+// addresses only.
+func NewHTTPResolver(timeout time.Duration, hosts []string) HTTPResolver {
+	h := HTTPResolver{
+		Hosts: hosts,
+	}
+
+	dialer := net.Dialer{
+		Timeout: timeout,
+		Control: refusePrivate,
+	}
+
+	h.Client = &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			if !h.allowed(req.URL.Hostname()) {
+				return fmt.Errorf("%w: redirect to %s", ErrProbeRefused, req.URL.Hostname())
+			}
+			return nil
+		},
+	}
+
+	return h
+}
+
+// ContentType implements the ContentTypeResolver interface
+func (h HTTPResolver) ContentType(ctx context.Context, resource url.URL) (string, error) {
+	if resource.Scheme != "http" && resource.Scheme != "https" {
+		return "", fmt.Errorf("cannot resolve content type for scheme %q", resource.Scheme)
+	}
+
+	if !h.allowed(resource.Hostname()) {
+		return "", fmt.Errorf("%w: host %s", ErrProbeRefused, resource.Hostname())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, resource.String(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("probing content type: %s", resp.Status)
+	}
+
+	// drop parameters such as charset so globs like video/* apply
+	ct, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return "", fmt.Errorf("parsing content type: %w", err)
+	}
+
+	return ct, nil
+}
+
+// allowed reports whether host is on the allowlist, or any host when there is none
+func (h HTTPResolver) allowed(host string) bool {
+	if len(h.Hosts) == 0 {
+		return true
+	}
+
+	host = strings.ToLower(host)
+	for _, a := range h.Hosts {
+		a = strings.ToLower(strings.TrimSpace(a))
+		switch {
+		case a == "":
+		case strings.HasPrefix(a, "."):
+			if host == a[1:] || strings.HasSuffix(host, a) {
+				return true
+			}
+		case host == a:
+			return true
+		}
+	}
+
+	return false
+}
+
+// refusePrivate stops a probe connecting to an address inside the cluster
+// or the host. It runs after name resolution, so a public name that
+// resolves to a private address is refused too.
+func refusePrivate(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !publicIP(ip) {
+		return fmt.Errorf("%w: address %s", ErrProbeRefused, host)
+	}
+
+	return nil
+}
+
+// sharedSpace is the carrier-grade NAT range, which is not routable on the internet
+var sharedSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicIP reports whether ip is a unicast address on the public internet
+func publicIP(ip net.IP) bool {
+	switch {
+	case ip.IsLoopback(), ip.IsPrivate(), ip.IsUnspecified(),
+		ip.IsLinkLocalUnicast(), ip.IsLinkLocalMulticast(),
+		ip.IsInterfaceLocalMulticast(), ip.IsMulticast():
+		return false
+	case sharedSpace.Contains(ip):
+		return false
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+package task
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+// dedupKey identifies the task a template builds from a resource with a given
+// build of the Templater. Two submissions sharing a key would do the same work.
+func dedupKey(resource url.URL, template string, version string) string {
+	return strings.Join([]string{version, template, identity(resource)}, "|")
+}
+
+// identity reduces a resource url to the form used to spot a resource that
+// has already been submitted. Scheme and host are lower cased, default ports,
+// fragments and trailing slashes are dropped, and the https forms of S3 and
+// Cloud Storage object urls are rewritten to the s3:// and gs:// forms used
+// by the seed data.
+func identity(resource url.URL) string {
+	scheme := strings.ToLower(resource.Scheme)
+	host := strings.ToLower(resource.Hostname())
+	p := resource.Path
+
+	if port := resource.Port(); port != "" && !defaultPort(scheme, port) {
+		host = host + ":" + port
+	}
+
+	switch scheme {
+	case "gcs":
+		scheme = "gs"
+	case "http", "https":
+		if bucket, key, ok := s3Object(host, p); ok {
+			scheme, host, p = "s3", bucket, key
+		} else if bucket, key, ok := gsObject(host, p); ok {
+			scheme, host, p = "gs", bucket, key
+		}
+	}
+
+	// object stores have no directories, so repeated and trailing slashes carry no meaning
+	if p != "" {
+		p = path.Clean("/" + p)
+		if p == "/" {
+			p = ""
+		}
+	}
+
+	u := url.URL{
+		Scheme:   scheme,
+		Host:     host,
+		Path:     p,
+		RawQuery: resource.Query().Encode(),
+	}
+
+	return u.String()
+}
+
+// s3Object recognises the virtual hosted and path style https forms of an S3 object url
+//
+//	https://<bucket>.s3[.<region>].amazonaws.com/<key>
+//	https://s3[.<region>].amazonaws.com/<bucket>/<key>
+func s3Object(host string, p string) (bucket string, key string, ok bool) {
+	if !strings.HasSuffix(host, ".amazonaws.com") {
+		return "", "", false
+	}
+	labels := strings.Split(strings.TrimSuffix(host, ".amazonaws.com"), ".")
+
+	for i, label := range labels {
+		if label != "s3" && !strings.HasPrefix(label, "s3-") {
+			continue
+		}
+		if i == 0 {
+			return splitBucket(p)
+		}
+		return strings.Join(labels[:i], "."), p, true
+	}
+
+	return "", "", false
+}
+
+// gsObject recognises the https forms of a Cloud Storage object url
+//
+//	https://<bucket>.storage.googleapis.com/<key>
+//	https://storage.googleapis.com/<bucket>/<key>
+func gsObject(host string, p string) (bucket string, key string, ok bool) {
+	switch {
+	case host == "storage.googleapis.com":
+		return splitBucket(p)
+	case strings.HasSuffix(host, ".storage.googleapis.com"):
+		return strings.TrimSuffix(host, ".storage.googleapis.com"), p, true
+	}
+	return "", "", false
+}
+
+// splitBucket takes the bucket from the front of a path style object path
+func splitBucket(p string) (bucket string, key string, ok bool) {
+	parts := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 2)
+	if parts[0] == "" {
+		return "", "", false
+	}
+	if len(parts) == 1 {
+		return strings.ToLower(parts[0]), "", true
+	}
+	return strings.ToLower(parts[0]), "/" + parts[1], true
+}
+
+// defaultPort reports whether port is the one scheme uses when none is given
+func defaultPort(scheme string, port string) bool {
+	return (scheme == "http" && port == "80") || (scheme == "https" && port == "443")
+}
@@ -0,0 +1,80 @@
+package task
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestIdentity(t *testing.T) {
+	tests := []struct {
+		name     string
+		resource string
+		want     string
+	}{
+		{"s3 url", "s3://bucket/videos/a.mp4", "s3://bucket/videos/a.mp4"},
+		{"case of scheme and host", "HTTPS://Example.COM/Videos/a.mp4", "https://example.com/Videos/a.mp4"},
+		{"default http port", "http://example.com:80/a.mp4", "http://example.com/a.mp4"},
+		{"default https port", "https://example.com:443/a.mp4", "https://example.com/a.mp4"},
+		{"other port kept", "https://example.com:8443/a.mp4", "https://example.com:8443/a.mp4"},
+		{"fragment dropped", "https://example.com/a.mp4#t=10", "https://example.com/a.mp4"},
+		{"trailing slash", "s3://bucket/videos/", "s3://bucket/videos"},
+		{"repeated slashes", "s3://bucket//videos///a.mp4", "s3://bucket/videos/a.mp4"},
+		{"bucket only", "s3://bucket/", "s3://bucket"},
+		{"query sorted", "https://example.com/a.mp4?b=2&a=1", "https://example.com/a.mp4?a=1&b=2"},
+		{"gcs alias", "gcs://bucket/a.mp4", "gs://bucket/a.mp4"},
+		{"s3 virtual hosted", "https://bucket.s3.amazonaws.com/a.mp4", "s3://bucket/a.mp4"},
+		{"s3 virtual hosted region", "https://bucket.s3.eu-west-1.amazonaws.com/a.mp4", "s3://bucket/a.mp4"},
+		{"s3 dashed region", "https://bucket.s3-us-west-2.amazonaws.com/a.mp4", "s3://bucket/a.mp4"},
+		{"s3 path style", "https://s3.amazonaws.com/bucket/a.mp4", "s3://bucket/a.mp4"},
+		{"s3 dotted bucket", "https://my.bucket.s3.amazonaws.com/a.mp4", "s3://my.bucket/a.mp4"},
+		{"gs virtual hosted", "https://bucket.storage.googleapis.com/a.mp4", "gs://bucket/a.mp4"},
+		{"gs path style", "https://storage.googleapis.com/bucket/a.mp4", "gs://bucket/a.mp4"},
+		{"other aws host", "https://ec2.amazonaws.com/a.mp4", "https://ec2.amazonaws.com/a.mp4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.resource)
+			if err != nil {
+				t.Fatalf("parsing %q: %v", tt.resource, err)
+			}
+
+			if got := identity(*u); got != tt.want {
+				t.Fatalf("identity(%q) = %q, want %q", tt.resource, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDedupKey(t *testing.T) {
+	parse := func(s string) url.URL {
+		u, err := url.Parse(s)
+		if err != nil {
+			t.Fatalf("parsing %q: %v", s, err)
+		}
+		return *u
+	}
+
+	a := dedupKey(parse("https://bucket.s3.amazonaws.com/a.mp4"), "thumbnail", "v1")
+	b := dedupKey(parse("s3://bucket/a.mp4"), "thumbnail", "v1")
+	if a != b {
+		t.Fatalf("forms of the same object got keys %q and %q", a, b)
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		version  string
+	}{
+		{"other template", "transcode", "v1"},
+		{"other build", "thumbnail", "v2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dedupKey(parse("s3://bucket/a.mp4"), tt.template, tt.version); got == a {
+				t.Fatalf("dedupKey = %q, want a key other than %q", got, a)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jnkroeker/khyme/business/data/store/task"
+	"github.com/jnkroeker/khyme/business/sys/validate"
+)
+
+// Set of errors returned when a result manifest cannot be accepted
+var (
+	ErrResultsNotSucceeded = errors.New("results may only be reported when a task succeeds")
+	ErrResultOutsideOutput = errors.New("result url is not under the task output_url")
+	ErrDuplicateResult     = errors.New("result url is listed more than once")
+)
+
+// AddResults records a manifest sent apart from the status update, such as
+// when the response to that update was lost. It is accepted only while the
+// task is succeeded and only from the worker that ran it, and returns the
+// whole manifest of the task.
+func (c Core) AddResults(ctx context.Context, taskID string, ar task.AddResults, now time.Time) ([]task.Result, error) {
+
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	if err := validate.CheckID(taskID); err != nil {
+		return nil, err
+	}
+
+	var results []task.Result
+	tran := func(tx sqlx.ExtContext) error {
+		core := c.Tran(tx)
+
+		// the lock holds the task succeeded until the manifest is in
+		t, err := core.task.LockByID(ctx, taskID)
+		if err != nil {
+			return err
+		}
+
+		if t.Status != task.StatusSucceeded {
+			return ErrResultsNotSucceeded
+		}
+		if t.WorkerID == nil || *t.WorkerID != ar.WorkerID {
+			return task.ErrNotLeaseHolder
+		}
+
+		if err := core.addResults(ctx, t, ar.Results, now); err != nil {
+			return err
+		}
+
+		results, err = core.task.QueryResults(ctx, taskID)
+		return err
+	}
+
+	if err := c.task.WithinTran(ctx, tran); err != nil {
+		return nil, fmt.Errorf("add results: %w", err)
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	return results, nil
+}
+
+// addResults records the manifest of objects a task that succeeded
+// produced. Every object must lie under the output url of the task and
+// be listed once.
+// The Core is expected to be bound to a transaction.
+func (c Core) addResults(ctx context.Context, t task.Task, nrs []task.NewResult, now time.Time) error {
+	if len(nrs) == 0 {
+		return nil
+	}
+
+	seen := make(map[string]bool, len(nrs))
+	results := make([]task.Result, len(nrs))
+	for i, nr := range nrs {
+		if !underOutput(t.OutputResource, nr.URL) {
+			return fmt.Errorf("%w: %s", ErrResultOutsideOutput, nr.URL)
+		}
+
+		// spellings of the same object are stored as one url
+		u, err := cleanURL(nr.URL)
+		if err != nil {
+			return fmt.Errorf("parsing result url: %w", err)
+		}
+		key := u.String()
+		if seen[key] {
+			return fmt.Errorf("%w: %s", ErrDuplicateResult, nr.URL)
+		}
+		seen[key] = true
+
+		results[i] = task.Result{
+			ID:          validate.GenerateID(),
+			TaskID:      t.ID,
+			URL:         key,
+			Size:        nr.Size,
+			Checksum:    nr.Checksum,
+			ContentType: nr.ContentType,
+			Metadata:    nr.Metadata,
+			DateCreated: now,
+		}
+	}
+
+	if err := c.task.AddResults(ctx, results); err != nil {
+		return err
+	}
+
+	c.log.Infow("results", "task", t.ID, "count", len(results))
+
+	return nil
+}
+
+// underOutput reports whether resource is the output url of a task or lies
+// in the directory it names. Paths are compared once cleaned, so dot
+// segments cannot climb out of the directory. A task with no output url
+// accepts any url.
+func underOutput(output string, resource string) bool {
+	if output == "" {
+		return true
+	}
+
+	o, err := cleanURL(output)
+	if err != nil {
+		return false
+	}
+	r, err := cleanURL(resource)
+	if err != nil {
+		return false
+	}
+
+	if o.Scheme != r.Scheme || o.Host != r.Host {
+		return false
+	}
+
+	dir := path.Clean("/" + o.Path)
+	p := path.Clean("/" + r.Path)
+	return p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// cleanURL parses raw with its scheme and host lowercased and its path
+// cleaned, so every spelling of one object comes out the same.
+func cleanURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if u.Path != "" {
+		u.Path = path.Clean("/" + u.Path)
+		u.RawPath = ""
+	}
+
+	return u, nil
+}
+
+// QueryResults returns the manifest of objects a task produced
+func (c Core) QueryResults(ctx context.Context, taskID string) ([]task.Result, error) {
+
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	if err := validate.CheckID(taskID); err != nil {
+		return nil, err
+	}
+
+	if _, err := c.task.QueryByID(ctx, taskID); err != nil {
+		return nil, fmt.Errorf("query results: %w", err)
+	}
+
+	results, err := c.task.QueryResults(ctx, taskID)
+	if err != nil {
+		return nil, fmt.Errorf("query results: %w", err)
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	if results == nil {
+		results = []task.Result{}
+	}
+
+	return results, nil
+}
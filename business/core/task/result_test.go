@@ -0,0 +1,62 @@
+package task
+
+import "testing"
+
+func TestUnderOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		resource string
+		want     bool
+	}{
+		{"no output", "", "s3://any/thing", true},
+		{"the output itself", "s3://bucket/out/a.mp4", "s3://bucket/out/a.mp4", true},
+		{"inside the directory", "s3://bucket/out/", "s3://bucket/out/a.mp4", true},
+		{"directory without slash", "s3://bucket/out", "s3://bucket/out/a.mp4", true},
+		{"nested", "s3://bucket/out/", "s3://bucket/out/x/y/a.mp4", true},
+		{"bucket root", "s3://bucket/", "s3://bucket/a.mp4", true},
+		{"scheme case", "s3://bucket/out/", "S3://bucket/out/a.mp4", true},
+		{"sibling sharing a prefix", "s3://bucket/out", "s3://bucket/output/a.mp4", false},
+		{"dot segments", "s3://bucket/out/", "s3://bucket/out/../secret/a.mp4", false},
+		{"encoded dot segments", "s3://bucket/out/", "s3://bucket/out/%2e%2e/secret/a.mp4", false},
+		{"other bucket", "s3://bucket/out/", "s3://bucket-2/out/a.mp4", false},
+		{"other scheme", "s3://bucket/out/", "gs://bucket/out/a.mp4", false},
+		{"bad url", "s3://bucket/out/", "s3://bucket/out/%zz", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := underOutput(tt.output, tt.resource); got != tt.want {
+				t.Fatalf("underOutput(%q, %q) = %t, want %t", tt.output, tt.resource, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCleanURL(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"already clean", "s3://bucket/out/a.mp4", "s3://bucket/out/a.mp4"},
+		{"doubled slash", "s3://bucket/out//a.mp4", "s3://bucket/out/a.mp4"},
+		{"dot segments", "s3://bucket/out/./x/../a.mp4", "s3://bucket/out/a.mp4"},
+		{"encoded dot segments", "s3://bucket/out/%2e/a.mp4", "s3://bucket/out/a.mp4"},
+		{"scheme and host case", "S3://Bucket/out/a.mp4", "s3://bucket/out/a.mp4"},
+		{"path case kept", "s3://bucket/Out/A.mp4", "s3://bucket/Out/A.mp4"},
+		{"no path", "s3://bucket", "s3://bucket"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := cleanURL(tt.raw)
+			if err != nil {
+				t.Fatalf("cleanURL(%q) error = %v", tt.raw, err)
+			}
+			if got := u.String(); got != tt.want {
+				t.Fatalf("cleanURL(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
@@ -2,49 +2,404 @@ package task
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/url"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/jnkroeker/khyme/business/data/store/event"
+	"github.com/jnkroeker/khyme/business/data/store/idempotency"
+	"github.com/jnkroeker/khyme/business/data/store/pipeline"
 	"github.com/jnkroeker/khyme/business/data/store/task"
+	"github.com/jnkroeker/khyme/business/sys/database"
+	"github.com/jnkroeker/khyme/business/sys/validate"
 	"go.uber.org/zap"
 )
 
+// Set of error variables
+var (
+	ErrInvalidResource = errors.New("input_url is not a valid url")
+	ErrNoTemplate      = errors.New("no template matches input_url")
+	ErrUnknownTemplate = errors.New("template is not registered")
+	ErrInvalidDelay    = errors.New("delay must be a positive duration such as 15m")
+	ErrInvalidRetry    = errors.New("retry backoffs must be positive durations such as 30s")
+	ErrNotScheduled    = errors.New("task is not pending")
+	ErrTaskActive      = errors.New("task has not finished, cancel it first or delete with force")
+	ErrReportFailure   = errors.New("failures are reported with POST /v1/tasks/:id/fail so they are retried")
+
+	ErrUnknownDependency = errors.New("depends_on names a task that does not exist")
+	ErrDependencyFailed  = errors.New("depends_on names a task that failed or was cancelled")
+	ErrDuplicateDepends  = errors.New("depends_on cannot be added to a task that already exists, submit with force")
+)
+
+// Bounds on the number of tasks returned in one page of a listing
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 1000
+)
+
+// Config contains the settings task handling needs from the service configuration
+type Config struct {
+	IdempotencyWindow time.Duration
+	TrashRetention    time.Duration
+}
+
 type Core struct {
-	log  *zap.SugaredLogger
-	task task.Store
+	log         *zap.SugaredLogger
+	cfg         Config
+	task        task.Store
+	idempotency idempotency.Store
+	pipeline    pipeline.Store
+	event       event.Store
+	templater   Templater
 }
 
-func NewCore(log *zap.SugaredLogger, db *sqlx.DB) Core {
+func NewCore(log *zap.SugaredLogger, db *sqlx.DB, templater Templater, cfg Config) Core {
 	return Core{
-		log:  log,
-		task: task.NewStore(log, db),
+		log:         log,
+		cfg:         cfg,
+		task:        task.NewStore(log, db),
+		idempotency: idempotency.NewStore(log, db),
+		pipeline:    pipeline.NewStore(log, db),
+		event:       event.NewStore(log, db),
+		templater:   templater,
 	}
 }
 
-func (c Core) Create(ctx context.Context, url string, now time.Time) (task.Task, error) {
+// Tran returns a copy of the Core whose work joins the provided transaction
+func (c Core) Tran(tx sqlx.ExtContext) Core {
+	c.task = c.task.Tran(tx)
+	c.idempotency = c.idempotency.Tran(tx)
+	c.pipeline = c.pipeline.Tran(tx)
+	c.event = c.event.Tran(tx)
+	return c
+}
+
+// Create templates the submitted resource and stores the resulting tasks.
+// The tasks of a fan-out template are created together, sharing a group id,
+// or not at all. When the resource already has live or succeeded tasks from
+// the same template and build, those are returned instead and created is
+// false, unless the submission is forced.
+func (c Core) Create(ctx context.Context, ntr task.NewTaskRequest, now time.Time) ([]task.Task, bool, error) {
 
 	// PERFORM PRE BUSINESS OPERATIONS
 
-	// create the task based on the user input
-	newTask := CreateTask(url)
+	// create the tasks from the template matching the user input
+	match, err := c.template(ctx, ntr, now)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var res []task.Task
+	var created bool
+	tran := func(tx sqlx.ExtContext) error {
+		res, created, err = c.Tran(tx).create(ctx, match, ntr.DependsOn, event.Caller(ctx), now)
+		return err
+	}
+
+	if err := retryDuplicate(func() error { return c.task.WithinTran(ctx, tran) }); err != nil {
+		return nil, false, fmt.Errorf("create: %w", err)
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	return res, created, nil
+}
+
+// create stores the tasks of a match, giving the tasks of a fan-out
+// template a shared group id, and makes each depend on the dependsOn tasks.
+// If any of the tasks is a duplicate, the tasks already holding its dedup
+// key are returned and nothing is stored. The Core is expected to be bound
+// to a transaction.
+func (c Core) create(ctx context.Context, match Match, dependsOn []string, actor string, now time.Time) ([]task.Task, bool, error) {
+	store := c.task
 
-	res, err := c.task.Create(ctx, newTask, now)
+	for _, nt := range match.Tasks {
+		if nt.DedupKey == nil {
+			continue
+		}
 
+		dup, err := store.QueryByDedupKey(ctx, *nt.DedupKey)
+		switch {
+		case err == nil:
+			// the original keeps the dependencies it was created with
+			if len(dependsOn) > 0 {
+				return nil, false, fmt.Errorf("%w: %s", ErrDuplicateDepends, dup.ID)
+			}
+			res, err := original(ctx, store, dup.ID)
+			return res, false, err
+		case !errors.Is(err, database.ErrNotFound):
+			return nil, false, err
+		}
+	}
+
+	if err := checkDependencies(ctx, store, dependsOn); err != nil {
+		return nil, false, err
+	}
+
+	var groupID *string
+	if match.FanOut {
+		id := validate.GenerateID()
+		groupID = &id
+	}
+
+	res := make([]task.Task, 0, len(match.Tasks))
+	for _, nt := range match.Tasks {
+		nt.GroupID = groupID
+
+		t, err := store.Create(ctx, nt, now)
+		if err != nil {
+			return nil, false, err
+		}
+		if err := store.AddDependencies(ctx, t.ID, dependsOn); err != nil {
+			return nil, false, err
+		}
+
+		ne := event.NewEvent{
+			TaskID:  t.ID,
+			Type:    event.TypeCreated,
+			To:      t.Status,
+			Actor:   actor,
+			Message: fmt.Sprintf("template %s: %s", t.Template, t.MatchReason),
+		}
+		if _, err := c.event.Record(ctx, ne, now); err != nil {
+			return nil, false, err
+		}
+
+		res = append(res, t)
+	}
+
+	return res, true, nil
+}
+
+// checkDependencies makes sure every task in dependsOn exists and can still
+// succeed. The parents stay locked until the transaction ends, so none can
+// fail or be deleted before the dependencies on it are stored.
+func checkDependencies(ctx context.Context, store task.Store, dependsOn []string) error {
+	if len(dependsOn) == 0 {
+		return nil
+	}
+
+	for _, id := range dependsOn {
+		if err := validate.CheckID(id); err != nil {
+			return fmt.Errorf("%w: %s", ErrUnknownDependency, id)
+		}
+	}
+
+	parents, err := store.LockByIDs(ctx, dependsOn)
 	if err != nil {
-		return task.Task{}, fmt.Errorf("create: %w", err)
+		return err
+	}
+
+	found := make(map[string]bool, len(parents))
+	for _, p := range parents {
+		found[p.ID] = true
+		if p.Status == task.StatusFailed || p.Status == task.StatusCancelled {
+			return fmt.Errorf("%w: %s", ErrDependencyFailed, p.ID)
+		}
+	}
+	for _, id := range dependsOn {
+		if !found[id] {
+			return fmt.Errorf("%w: %s", ErrUnknownDependency, id)
+		}
+	}
+
+	return nil
+}
+
+// retryDuplicate runs fn a second time when it lost a race to create a
+// duplicate task, so the second run finds and returns the winner's task.
+func retryDuplicate(fn func() error) error {
+	err := fn()
+	if errors.Is(err, database.ErrDBDuplicatedEntry) {
+		err = fn()
+	}
+	return err
+}
+
+// DryRun returns the tasks Create would store for the submission, without storing them
+func (c Core) DryRun(ctx context.Context, ntr task.NewTaskRequest, now time.Time) (Match, error) {
+	match, err := c.template(ctx, ntr, now)
+	if err != nil {
+		return Match{}, err
+	}
+
+	return match, nil
+}
+
+// CreateMatch stores the tasks of a match worked out beforehand with DryRun,
+// so a caller can template outside of its transaction. The Core is
+// expected to be bound to a transaction.
+func (c Core) CreateMatch(ctx context.Context, match Match, now time.Time) ([]task.Task, bool, error) {
+	return c.create(ctx, match, nil, event.Caller(ctx), now)
+}
+
+// Templates describes the templates submissions are matched against
+func (c Core) Templates() []TemplateInfo {
+	return c.templater.Templates()
+}
+
+// template runs a submission through the Templater and applies
+// the per request settings to the tasks it produces.
+func (c Core) template(ctx context.Context, ntr task.NewTaskRequest, now time.Time) (Match, error) {
+	resource, err := url.Parse(ntr.InputResource)
+	if err != nil || resource.Scheme == "" {
+		return Match{}, ErrInvalidResource
+	}
+
+	var match *Match
+	switch {
+	case ntr.Template != "":
+		if match = c.templater.Use(ctx, ntr.Template, *resource); match == nil {
+			return Match{}, ErrUnknownTemplate
+		}
+	default:
+		if match = c.templater.Create(ctx, *resource); match == nil {
+			return Match{}, ErrNoTemplate
+		}
+	}
+
+	notBefore, err := schedule(ntr, now)
+	if err != nil {
+		return Match{}, err
+	}
+
+	retry, err := retryOverride(ntr.Retry)
+	if err != nil {
+		return Match{}, err
+	}
+
+	var callbackURL *string
+	if ntr.CallbackURL != "" {
+		callbackURL = &ntr.CallbackURL
+	}
+
+	for i := range match.Tasks {
+		nt := &match.Tasks[i]
+		// forced submissions hold no dedup key, so they never collide
+		if !ntr.Force {
+			k := dedupKey(*resource, nt.Template, nt.Version)
+			nt.DedupKey = &k
+		}
+		nt.Labels = ntr.Labels
+		nt.CallbackURL = callbackURL
+		nt.Parameters = ntr.Parameters
+		nt.NotBefore = notBefore
+
+		if ntr.Priority != nil {
+			nt.Priority = *ntr.Priority
+		}
+
+		// the caller may tune how these tasks are retried
+		nt.RetryPolicy = overrideRetry(nt.RetryPolicy, retry)
+	}
+
+	return *match, nil
+}
+
+// QueryGroup reports how far the tasks of a fan-out group have got
+func (c Core) QueryGroup(ctx context.Context, groupID string) (task.Group, error) {
+
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	if err := validate.CheckID(groupID); err != nil {
+		return task.Group{}, err
+	}
+
+	tasks, err := c.task.QueryGroup(ctx, groupID)
+	if err != nil {
+		return task.Group{}, fmt.Errorf("query group: %w", err)
 	}
 
 	// PERFORM POST BUSINESS OPERATIONS
 
-	return res, nil
+	return Summarize(groupID, tasks), nil
+}
+
+// Summarize counts a group's tasks by status. The group is complete once
+// every task has reached a terminal status.
+func Summarize(groupID string, tasks []task.Task) task.Group {
+	g := task.Group{
+		ID:        groupID,
+		Total:     len(tasks),
+		Counts:    make(map[task.Status]int),
+		Complete:  true,
+		Succeeded: true,
+		Tasks:     tasks,
+	}
+
+	for _, t := range tasks {
+		g.Counts[t.Status]++
+		if !t.Status.IsTerminal() {
+			g.Complete = false
+		}
+		if t.Status != task.StatusSucceeded {
+			g.Succeeded = false
+		}
+	}
+
+	return g
 }
 
-func (c Core) Delete(ctx context.Context, taskID string) error {
+// Delete moves a task to the trash, where it is kept for the trash retention
+// before it is purged. Only finished tasks are deleted unless force is set.
+func (c Core) Delete(ctx context.Context, taskID string, force bool, now time.Time) error {
 
 	// PERFORM PRE BUSINESS OPERATIONS
 
-	if err := c.task.Delete(ctx, taskID); err != nil {
+	if err := validate.CheckID(taskID); err != nil {
+		return err
+	}
+
+	tran := func(tx sqlx.ExtContext) error {
+		core := c.Tran(tx)
+
+		// the status is read under lock so the task cannot be requeued and
+		// claimed between the check and the delete
+		t, err := core.task.LockByID(ctx, taskID)
+		if err != nil {
+			return err
+		}
+
+		// a task a worker may still be running is cancelled first, unless forced
+		if !force && !t.Status.IsTerminal() {
+			return ErrTaskActive
+		}
+
+		// a forced delete ends the run there and then, as nothing looks at
+		// tasks in the trash to ever finish it
+		if !t.Status.IsTerminal() {
+			const reason = "task deleted"
+			aborted, err := core.task.Abort(ctx, taskID, reason, now)
+			if err != nil {
+				return err
+			}
+			if err := core.event.RecordStatus(ctx, []task.Task{aborted}, t.Status, event.Caller(ctx), reason, now); err != nil {
+				return err
+			}
+			if err := core.abandon(ctx, taskID, now); err != nil {
+				return err
+			}
+		}
+
+		if err := core.task.Delete(ctx, taskID, now); err != nil {
+			return err
+		}
+
+		ne := event.NewEvent{
+			TaskID: taskID,
+			Type:   event.TypeDeleted,
+			Actor:  event.Caller(ctx),
+		}
+		if force {
+			ne.Message = "forced"
+		}
+		_, err = core.event.Record(ctx, ne, now)
+		return err
+	}
+
+	if err := c.task.WithinTran(ctx, tran); err != nil {
 		return fmt.Errorf("delete: %w", err)
 	}
 
@@ -53,17 +408,523 @@ func (c Core) Delete(ctx context.Context, taskID string) error {
 	return nil
 }
 
-func (c Core) Query(ctx context.Context, pageNumber int, rowsPerPage int) ([]task.Task, error) {
+// abandon ends the pipeline run of a task deleted before it finished. Tasks
+// waiting on it can no longer run, so they are cancelled, along with any
+// runs they are the current stage of. The Core is expected to be bound to
+// a transaction.
+func (c Core) abandon(ctx context.Context, taskID string, now time.Time) error {
+	if err := c.pipeline.Stop(ctx, taskID, task.StatusCancelled, fmt.Sprintf("task %s deleted", taskID), now); err != nil {
+		return err
+	}
+
+	reason := fmt.Sprintf("dependency %s deleted", taskID)
+	cancelled, err := c.task.CancelDependents(ctx, taskID, reason, now)
+	if err != nil {
+		return err
+	}
+	if err := c.event.RecordStatus(ctx, cancelled, task.StatusPending, event.ActorSystem, reason, now); err != nil {
+		return err
+	}
+	for _, d := range cancelled {
+		c.log.Infow("cancel dependent", "task", d.ID, "dependency", taskID)
+		if err := c.pipeline.Stop(ctx, d.ID, task.StatusCancelled, fmt.Sprintf("task %s cancelled", d.ID), now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Restore takes a task back out of the trash
+func (c Core) Restore(ctx context.Context, taskID string, now time.Time) (task.Task, error) {
 
 	// PERFORM PRE BUSINESS OPERATIONS
 
-	tasks, err := c.task.Query(ctx, pageNumber, rowsPerPage)
+	if err := validate.CheckID(taskID); err != nil {
+		return task.Task{}, err
+	}
+
+	var res task.Task
+	tran := func(tx sqlx.ExtContext) error {
+		core := c.Tran(tx)
+
+		var err error
+		if res, err = core.task.Restore(ctx, taskID); err != nil {
+			return err
+		}
+
+		ne := event.NewEvent{
+			TaskID: taskID,
+			Type:   event.TypeRestored,
+			Actor:  event.Caller(ctx),
+		}
+		_, err = core.event.Record(ctx, ne, now)
+		return err
+	}
+
+	if err := c.task.WithinTran(ctx, tran); err != nil {
+		return task.Task{}, fmt.Errorf("restore: %w", err)
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	return res, nil
+}
+
+// QueryTrash returns a page of the deleted tasks still waiting to be purged
+func (c Core) QueryTrash(ctx context.Context, pageNumber int, rowsPerPage int) ([]task.Task, error) {
 
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	tasks, err := c.task.QueryTrash(ctx, pageNumber, rowsPerPage)
 	if err != nil {
-		return nil, fmt.Errorf("query: %w", err)
+		return nil, fmt.Errorf("query trash: %w", err)
 	}
 
 	// PERFORM POST BUSINESS OPERATIONS
 
 	return tasks, nil
 }
+
+// PurgeTrash removes for good the tasks deleted longer ago than the trash retention
+func (c Core) PurgeTrash(ctx context.Context, now time.Time) (int, error) {
+	n, err := c.task.Purge(ctx, now.Add(-c.cfg.TrashRetention))
+	if err != nil {
+		return 0, fmt.Errorf("purge trash: %w", err)
+	}
+
+	return n, nil
+}
+
+// QueryByID gets the specified task
+func (c Core) QueryByID(ctx context.Context, taskID string) (task.Task, error) {
+
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	if err := validate.CheckID(taskID); err != nil {
+		return task.Task{}, err
+	}
+
+	res, err := c.task.QueryByID(ctx, taskID)
+	if err != nil {
+		return task.Task{}, fmt.Errorf("query by id: %w", err)
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	res.ETA = eta(res)
+
+	return res, nil
+}
+
+// eta estimates when an active task will finish from the rate at which it
+// reached the last progress its worker reported. There is no estimate
+// until the worker reports some progress.
+func eta(t task.Task) *time.Time {
+	if t.Status != task.StatusClaimed && t.Status != task.StatusRunning {
+		return nil
+	}
+
+	p := t.Progress
+	if p == nil || p.Percent <= 0 || p.Percent >= 100 {
+		return nil
+	}
+
+	start := t.StartedAt
+	if start == nil {
+		start = t.ClaimedAt
+	}
+	if start == nil || !p.DateUpdated.After(*start) {
+		return nil
+	}
+
+	elapsed := p.DateUpdated.Sub(*start)
+	finish := start.Add(time.Duration(float64(elapsed) * 100 / p.Percent))
+
+	return &finish
+}
+
+// QueryProgress returns the progress history of a task, oldest first.
+// History is only kept when the queue is configured to keep it.
+func (c Core) QueryProgress(ctx context.Context, taskID string) ([]task.ProgressReport, error) {
+
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	if err := validate.CheckID(taskID); err != nil {
+		return nil, err
+	}
+
+	if _, err := c.task.QueryByID(ctx, taskID); err != nil {
+		return nil, fmt.Errorf("query progress: %w", err)
+	}
+
+	reports, err := c.task.QueryProgress(ctx, taskID)
+	if err != nil {
+		return nil, fmt.Errorf("query progress: %w", err)
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	if reports == nil {
+		reports = []task.ProgressReport{}
+	}
+
+	return reports, nil
+}
+
+// QueryEvents returns the history of a task, oldest first
+func (c Core) QueryEvents(ctx context.Context, taskID string) ([]event.Event, error) {
+
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	if err := validate.CheckID(taskID); err != nil {
+		return nil, err
+	}
+
+	if _, err := c.task.QueryByID(ctx, taskID); err != nil {
+		return nil, fmt.Errorf("query events: %w", err)
+	}
+
+	events, err := c.event.QueryByTask(ctx, taskID)
+	if err != nil {
+		return nil, fmt.Errorf("query events: %w", err)
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	if events == nil {
+		events = []event.Event{}
+	}
+
+	return events, nil
+}
+
+// Query returns a page of the tasks matching filter. The page after it
+// is fetched by passing back the cursor it carries.
+func (c Core) Query(ctx context.Context, filter task.QueryFilter, ob task.OrderBy, cursor string, limit int, now time.Time) (task.Page, error) {
+
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	var after *task.Cursor
+	if cursor != "" {
+		cur, err := task.ParseCursor(cursor, ob)
+		if err != nil {
+			return task.Page{}, err
+		}
+		after = &cur
+	}
+
+	switch {
+	case limit <= 0:
+		limit = DefaultPageSize
+	case limit > MaxPageSize:
+		limit = MaxPageSize
+	}
+
+	// ask for one row more than the page holds to learn whether another page follows
+	tasks, err := c.task.Query(ctx, filter, ob, after, limit+1, now)
+	if err != nil {
+		return task.Page{}, fmt.Errorf("query: %w", err)
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	page := task.Page{
+		Tasks: tasks,
+	}
+	if page.Tasks == nil {
+		page.Tasks = []task.Task{}
+	}
+	if len(tasks) > limit {
+		page.Tasks = tasks[:limit]
+		page.NextCursor = task.NewCursor(page.Tasks[limit-1], ob).Encode()
+	}
+
+	return page, nil
+}
+
+func (c Core) UpdateStatus(ctx context.Context, taskID string, us task.UpdateStatus, now time.Time) (task.Task, error) {
+
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	if err := validate.CheckID(taskID); err != nil {
+		return task.Task{}, err
+	}
+
+	status, err := task.ParseStatus(us.Status)
+	if err != nil {
+		return task.Task{}, err
+	}
+
+	// a failure set here would skip the retry policy and the dead-letter queue
+	if status == task.StatusFailed {
+		return task.Task{}, ErrReportFailure
+	}
+
+	if len(us.Results) > 0 && status != task.StatusSucceeded {
+		return task.Task{}, ErrResultsNotSucceeded
+	}
+
+	// templating the next stage of a pipeline may probe its input over the
+	// network, so it is done before the transaction is begun
+	var next *stage
+	if status == task.StatusSucceeded {
+		if next, err = c.nextStage(ctx, taskID, now); err != nil {
+			return task.Task{}, fmt.Errorf("update status: %w", err)
+		}
+	}
+
+	// a report from a worker is put down to the worker, not the api
+	actor := event.Caller(ctx)
+	if us.WorkerID != "" {
+		actor = event.Worker(us.WorkerID)
+	}
+
+	var res task.Task
+	tran := func(tx sqlx.ExtContext) error {
+		core := c.Tran(tx)
+
+		var err error
+		if res, err = core.updateStatus(ctx, taskID, status, us.WorkerID, next, actor, now); err != nil {
+			return err
+		}
+
+		return core.addResults(ctx, res, us.Results, now)
+	}
+
+	if err := c.task.WithinTran(ctx, tran); err != nil {
+		return task.Task{}, fmt.Errorf("update status: %w", err)
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	return res, nil
+}
+
+// updateStatus moves a task to status on behalf of actor and carries the
+// change on to the pipeline run and dependents of the task. workerID must
+// hold the lease of a claimed or running task. next is the templated next
+// stage of the run, when the task succeeded. The Core is expected to be
+// bound to a transaction.
+func (c Core) updateStatus(ctx context.Context, taskID string, status task.Status, workerID string, next *stage, actor string, now time.Time) (task.Task, error) {
+	res, from, err := c.task.UpdateStatus(ctx, taskID, status, workerID, now)
+	if err != nil {
+		return task.Task{}, err
+	}
+
+	if err := c.event.RecordStatus(ctx, []task.Task{res}, from, actor, "", now); err != nil {
+		return task.Task{}, err
+	}
+
+	// a pipeline run moves on to its next stage, or ends, with its task
+	if err := c.advance(ctx, res, next, now); err != nil {
+		return task.Task{}, err
+	}
+
+	// tasks waiting on one that can no longer succeed are cancelled with it
+	if status != task.StatusFailed && status != task.StatusCancelled {
+		return res, nil
+	}
+	reason := fmt.Sprintf("dependency %s %s", taskID, status)
+	cancelled, err := c.task.CancelDependents(ctx, taskID, reason, now)
+	if err != nil {
+		return task.Task{}, err
+	}
+	if err := c.event.RecordStatus(ctx, cancelled, task.StatusPending, event.ActorSystem, reason, now); err != nil {
+		return task.Task{}, err
+	}
+	for _, d := range cancelled {
+		if err := c.advance(ctx, d, nil, now); err != nil {
+			return task.Task{}, err
+		}
+	}
+
+	return res, nil
+}
+
+// Cancel stops a task. A pending task is cancelled straight away. A task
+// held by a worker is marked for cancelling: the worker is told on its next
+// heartbeat and reports the task cancelled once it has stopped it.
+func (c Core) Cancel(ctx context.Context, taskID string, now time.Time) (task.Task, error) {
+
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	if err := validate.CheckID(taskID); err != nil {
+		return task.Task{}, err
+	}
+
+	actor := event.Caller(ctx)
+
+	var res task.Task
+	tran := func(tx sqlx.ExtContext) error {
+		core := c.Tran(tx)
+
+		var err error
+		res, err = core.task.RequestCancel(ctx, taskID, now)
+		switch {
+		case err == nil:
+			ne := event.NewEvent{
+				TaskID: taskID,
+				Type:   event.TypeCancelRequested,
+				Actor:  actor,
+			}
+			_, err = core.event.Record(ctx, ne, now)
+			return err
+		case !errors.Is(err, database.ErrNotFound):
+			return err
+		}
+
+		// no worker holds the task; finished tasks refuse the move
+		res, err = core.updateStatus(ctx, taskID, task.StatusCancelled, "", nil, actor, now)
+		return err
+	}
+
+	if err := c.task.WithinTran(ctx, tran); err != nil {
+		return task.Task{}, fmt.Errorf("cancel: %w", err)
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	return res, nil
+}
+
+// RunNow releases a scheduled task so the next claim can take it
+func (c Core) RunNow(ctx context.Context, taskID string, now time.Time) (task.Task, error) {
+
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	if err := validate.CheckID(taskID); err != nil {
+		return task.Task{}, err
+	}
+
+	var res task.Task
+	tran := func(tx sqlx.ExtContext) error {
+		core := c.Tran(tx)
+
+		var err error
+		if res, err = core.task.RunNow(ctx, taskID); err != nil {
+			return err
+		}
+
+		ne := event.NewEvent{
+			TaskID: taskID,
+			Type:   event.TypeReleased,
+			Actor:  event.Caller(ctx),
+		}
+		_, err = core.event.Record(ctx, ne, now)
+		return err
+	}
+
+	if err := c.task.WithinTran(ctx, tran); err != nil {
+		if !errors.Is(err, database.ErrNotFound) {
+			return task.Task{}, fmt.Errorf("run now: %w", err)
+		}
+
+		// tell a missing task apart from one that is no longer waiting
+		if _, err := c.task.QueryByID(ctx, taskID); err != nil {
+			return task.Task{}, fmt.Errorf("run now: %w", err)
+		}
+		return task.Task{}, ErrNotScheduled
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	c.log.Infow("run now", "task", taskID)
+
+	return res, nil
+}
+
+// schedule works out when the submitted tasks may first be claimed.
+// Nil means straight away.
+func schedule(ntr task.NewTaskRequest, now time.Time) (*time.Time, error) {
+	switch {
+	case ntr.NotBefore != nil:
+		t := ntr.NotBefore.UTC()
+		return &t, nil
+	case ntr.Delay != "":
+		d, err := time.ParseDuration(ntr.Delay)
+		if err != nil || d < 0 {
+			return nil, ErrInvalidDelay
+		}
+		t := now.Add(d)
+		return &t, nil
+	}
+	return nil, nil
+}
+
+// retryOverride reads the retry policy a submission sets. Fields it
+// leaves out are nil, so they keep the template's setting.
+func retryOverride(rr *task.RetryRequest) (task.RetryPolicy, error) {
+	if rr == nil {
+		return task.RetryPolicy{}, nil
+	}
+
+	p := task.RetryPolicy{
+		MaxAttempts: rr.MaxAttempts,
+		Jitter:      rr.Jitter,
+	}
+
+	var err error
+	if p.Base, err = parseBackoff(rr.Base); err != nil {
+		return task.RetryPolicy{}, err
+	}
+	if p.Max, err = parseBackoff(rr.Max); err != nil {
+		return task.RetryPolicy{}, err
+	}
+
+	return p, nil
+}
+
+// parseBackoff reads a backoff such as "30s". An empty string is unset.
+func parseBackoff(s string) (*time.Duration, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return nil, ErrInvalidRetry
+	}
+
+	return &d, nil
+}
+
+// UpdatePriority moves a task up or down the queue. A task already claimed
+// keeps its priority for its next run should it be retried.
+func (c Core) UpdatePriority(ctx context.Context, taskID string, up task.UpdatePriority, now time.Time) (task.Task, error) {
+
+	// PERFORM PRE BUSINESS OPERATIONS
+
+	if err := validate.CheckID(taskID); err != nil {
+		return task.Task{}, err
+	}
+
+	var res task.Task
+	tran := func(tx sqlx.ExtContext) error {
+		core := c.Tran(tx)
+
+		var err error
+		if res, err = core.task.UpdatePriority(ctx, taskID, *up.Priority); err != nil {
+			return err
+		}
+
+		ne := event.NewEvent{
+			TaskID:  taskID,
+			Type:    event.TypePriorityChanged,
+			Actor:   event.Caller(ctx),
+			Message: fmt.Sprintf("priority %d", res.Priority),
+		}
+		_, err = core.event.Record(ctx, ne, now)
+		return err
+	}
+
+	if err := c.task.WithinTran(ctx, tran); err != nil {
+		return task.Task{}, fmt.Errorf("update priority: %w", err)
+	}
+
+	// PERFORM POST BUSINESS OPERATIONS
+
+	c.log.Infow("update priority", "task", taskID, "priority", res.Priority)
+
+	return res, nil
+}
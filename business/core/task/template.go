@@ -0,0 +1,357 @@
+package task
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jnkroeker/khyme/business/data/store/task"
+)
+
+// TemplateFile is the layout of the file templates and pipelines are loaded from
+type TemplateFile struct {
+	Templates []TemplateConfig `json:"templates"`
+	Pipelines []Pipeline       `json:"pipelines,omitempty"`
+}
+
+// TemplateConfig declares a Template: which resources it matches and
+// the task it produces for them.
+//
+// OutputURL is a pattern expanded against the matched resource. It may use
+// {scheme}, {host}, {path}, {dir}, {file}, {name} and {ext}, e.g. for
+// s3://bucket/videos/clip.mp4 those are s3, bucket, /videos/clip.mp4,
+// /videos, clip.mp4, clip and .mp4. It may also use ${NAME} for the
+// environment variable NAME, read once when the templates are loaded, so
+// that a mirror bucket can change without a new image.
+//
+// Priority is the default priority of the tasks, from 0 to 100. A submission
+// may replace it.
+//
+// A template that lists Tasks fans out: it produces one task per entry,
+// created together as a group. Entries inherit any field they leave unset
+// from the template itself.
+type TemplateConfig struct {
+	Name           string       `json:"name"`
+	Match          MatchConfig  `json:"match"`
+	ExecutionImage string       `json:"exec_image,omitempty"`
+	Hooks          string       `json:"hooks,omitempty"`
+	Timeout        Duration     `json:"timeout,omitempty"`
+	OutputURL      string       `json:"output_url,omitempty"`
+	Priority       int          `json:"priority,omitempty"`
+	Retry          RetryConfig  `json:"retry"`
+	Tasks          []TaskConfig `json:"tasks,omitempty"`
+}
+
+// TaskConfig declares one of the tasks a fan-out template produces.
+// Priority is a pointer so an entry can set it to 0 below its template.
+type TaskConfig struct {
+	Name           string      `json:"name"`
+	ExecutionImage string      `json:"exec_image,omitempty"`
+	Hooks          string      `json:"hooks,omitempty"`
+	Timeout        Duration    `json:"timeout,omitempty"`
+	OutputURL      string      `json:"output_url,omitempty"`
+	Priority       *int        `json:"priority,omitempty"`
+	Retry          RetryConfig `json:"retry"`
+}
+
+// RetryConfig is the file form of a task.RetryPolicy. A field left out
+// falls back to the template, then to the queue defaults.
+type RetryConfig struct {
+	MaxAttempts *int      `json:"max_attempts,omitempty"`
+	Base        *Duration `json:"backoff_base,omitempty"`
+	Max         *Duration `json:"backoff_max,omitempty"`
+	Jitter      *float64  `json:"backoff_jitter,omitempty"`
+}
+
+// policy converts the file form into the policy stored on a task
+func (rc RetryConfig) policy() task.RetryPolicy {
+	p := task.RetryPolicy{
+		MaxAttempts: rc.MaxAttempts,
+		Jitter:      rc.Jitter,
+	}
+	if rc.Base != nil {
+		d := time.Duration(*rc.Base)
+		p.Base = &d
+	}
+	if rc.Max != nil {
+		d := time.Duration(*rc.Max)
+		p.Max = &d
+	}
+	return p
+}
+
+// Duration is a time.Duration written as a string such as "90s" or "48h"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string: %w", err)
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+
+	return nil
+}
+
+// LoadTemplates reads template definitions from the JSON file at path
+func LoadTemplates(path string) ([]Template, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading templates: %w", err)
+	}
+
+	var tf TemplateFile
+	if err := json.Unmarshal(data, &tf); err != nil {
+		return nil, fmt.Errorf("decoding templates: %w", err)
+	}
+
+	templates := make([]Template, 0, len(tf.Templates))
+	names := make(map[string]bool)
+	for i, tc := range tf.Templates {
+		if names[tc.Name] {
+			return nil, fmt.Errorf("template[%d]: duplicate name %q", i, tc.Name)
+		}
+		names[tc.Name] = true
+
+		tmpl, err := NewTemplate(tc)
+		if err != nil {
+			return nil, fmt.Errorf("template[%d]: %w", i, err)
+		}
+		templates = append(templates, tmpl)
+	}
+
+	return templates, nil
+}
+
+// LoadPipelines reads pipeline definitions from the JSON file at path and
+// checks every stage names one of templates. A stage must produce a single
+// task, whose output is the input of the next stage, so fan-out templates
+// cannot be stages.
+func LoadPipelines(path string, templates []Template) ([]Pipeline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading pipelines: %w", err)
+	}
+
+	var tf TemplateFile
+	if err := json.Unmarshal(data, &tf); err != nil {
+		return nil, fmt.Errorf("decoding pipelines: %w", err)
+	}
+
+	byName := make(map[string]Template, len(templates))
+	for _, tmpl := range templates {
+		byName[tmpl.Name] = tmpl
+	}
+
+	names := make(map[string]bool)
+	for i, p := range tf.Pipelines {
+		switch {
+		case p.Name == "":
+			return nil, fmt.Errorf("pipeline[%d]: name is required", i)
+		case p.Name == "runs":
+			return nil, fmt.Errorf("pipeline[%d]: name %q is reserved", i, p.Name)
+		case names[p.Name]:
+			return nil, fmt.Errorf("pipeline[%d]: duplicate name %q", i, p.Name)
+		case len(p.Stages) == 0:
+			return nil, fmt.Errorf("pipeline[%d]: %s: stages are required", i, p.Name)
+		}
+		names[p.Name] = true
+
+		for j, stage := range p.Stages {
+			tmpl, ok := byName[stage]
+			switch {
+			case !ok:
+				return nil, fmt.Errorf("pipeline[%d]: %s: stages[%d]: unknown template %q", i, p.Name, j, stage)
+			case tmpl.FanOut:
+				return nil, fmt.Errorf("pipeline[%d]: %s: stages[%d]: fan-out template %q cannot be a stage", i, p.Name, j, stage)
+			}
+		}
+	}
+
+	return tf.Pipelines, nil
+}
+
+// NewTemplate turns a template definition into a Template
+func NewTemplate(tc TemplateConfig) (Template, error) {
+	if tc.Name == "" {
+		return Template{}, errors.New("name is required")
+	}
+
+	m, err := compileMatch(tc.Match)
+	if err != nil {
+		return Template{}, fmt.Errorf("%s: match: %w", tc.Name, err)
+	}
+
+	// a plain template is a fan-out of one task named after itself
+	members := tc.Tasks
+	if len(members) == 0 {
+		members = []TaskConfig{{Name: tc.Name}}
+	}
+
+	specs := make([]TaskConfig, 0, len(members))
+	names := make(map[string]bool)
+	for i, member := range members {
+		if names[member.Name] {
+			return Template{}, fmt.Errorf("%s: tasks[%d]: duplicate name %q", tc.Name, i, member.Name)
+		}
+		names[member.Name] = true
+
+		spec, err := inherit(tc, member)
+		if err != nil {
+			return Template{}, fmt.Errorf("%s: tasks[%d]: %w", tc.Name, i, err)
+		}
+		specs = append(specs, spec)
+	}
+
+	tmpl := Template{
+		Name:   tc.Name,
+		FanOut: len(tc.Tasks) > 0,
+		match:  m,
+		rule:   tc.Match,
+		specs:  specs,
+		Create: func(resource url.URL) []task.NewTask {
+			tasks := make([]task.NewTask, 0, len(specs))
+			for _, spec := range specs {
+				name := tc.Name
+				if len(tc.Tasks) > 0 {
+					name = tc.Name + "/" + spec.Name
+				}
+
+				tasks = append(tasks, task.NewTask{
+					InputResource:  resource.String(),
+					OutputResource: expandOutput(spec.OutputURL, resource),
+					Hooks:          spec.Hooks,
+					ExecutionImage: spec.ExecutionImage,
+					Timeout:        time.Duration(spec.Timeout),
+					Template:       name,
+					Priority:       *spec.Priority,
+					RetryPolicy:    spec.Retry.policy(),
+				})
+			}
+			return tasks
+		},
+	}
+
+	return tmpl, nil
+}
+
+// inherit fills the fields a task entry leaves unset from its template,
+// then checks the entry describes a task that can run.
+func inherit(tc TemplateConfig, member TaskConfig) (TaskConfig, error) {
+	if member.Name == "" {
+		return TaskConfig{}, errors.New("name is required")
+	}
+	if member.ExecutionImage == "" {
+		member.ExecutionImage = tc.ExecutionImage
+	}
+	if member.Hooks == "" {
+		member.Hooks = tc.Hooks
+	}
+	if member.Timeout == 0 {
+		member.Timeout = tc.Timeout
+	}
+	if member.OutputURL == "" {
+		member.OutputURL = tc.OutputURL
+	}
+	if member.Priority == nil {
+		priority := tc.Priority
+		member.Priority = &priority
+	}
+	if member.Retry.MaxAttempts == nil {
+		member.Retry.MaxAttempts = tc.Retry.MaxAttempts
+	}
+	if member.Retry.Base == nil {
+		member.Retry.Base = tc.Retry.Base
+	}
+	if member.Retry.Max == nil {
+		member.Retry.Max = tc.Retry.Max
+	}
+	if member.Retry.Jitter == nil {
+		member.Retry.Jitter = tc.Retry.Jitter
+	}
+
+	switch {
+	case member.ExecutionImage == "":
+		return TaskConfig{}, errors.New("exec_image is required")
+	case member.OutputURL == "":
+		return TaskConfig{}, errors.New("output_url is required")
+	case *member.Priority < 0 || *member.Priority > 100:
+		return TaskConfig{}, errors.New("priority must be from 0 to 100")
+	case member.Retry.MaxAttempts != nil && *member.Retry.MaxAttempts < 1:
+		return TaskConfig{}, errors.New("retry max_attempts must be at least 1")
+	case (member.Retry.Base != nil && *member.Retry.Base < 0) || (member.Retry.Max != nil && *member.Retry.Max < 0):
+		return TaskConfig{}, errors.New("retry backoffs must not be negative")
+	case member.Retry.Jitter != nil && (*member.Retry.Jitter < 0 || *member.Retry.Jitter > 1):
+		return TaskConfig{}, errors.New("retry backoff_jitter must be from 0 to 1")
+	}
+
+	out, err := expandEnv(member.OutputURL)
+	if err != nil {
+		return TaskConfig{}, fmt.Errorf("output_url: %w", err)
+	}
+	member.OutputURL = out
+
+	// make sure the pattern expands into a url before it is ever used
+	sample := url.URL{Scheme: "s3", Host: "bucket", Path: "/dir/file.ext"}
+	if _, err := url.Parse(expandOutput(member.OutputURL, sample)); err != nil {
+		return TaskConfig{}, fmt.Errorf("output_url: %w", err)
+	}
+
+	return member, nil
+}
+
+// envRef matches a ${NAME} reference to an environment variable
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces every ${NAME} in pattern with the value of the
+// environment variable NAME. A variable that is not set is an error.
+func expandEnv(pattern string) (string, error) {
+	var missing []string
+	out := envRef.ReplaceAllStringFunc(pattern, func(ref string) string {
+		name := envRef.FindStringSubmatch(ref)[1]
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return v
+	})
+
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable not set: %s", strings.Join(missing, ", "))
+	}
+
+	return out, nil
+}
+
+// expandOutput fills the placeholders of an output url pattern from resource
+func expandOutput(pattern string, resource url.URL) string {
+	dir, file := path.Split(resource.Path)
+	ext := path.Ext(file)
+
+	r := strings.NewReplacer(
+		"{scheme}", resource.Scheme,
+		"{host}", resource.Host,
+		"{path}", resource.Path,
+		"{dir}", strings.TrimSuffix(dir, "/"),
+		"{file}", file,
+		"{name}", strings.TrimSuffix(file, ext),
+		"{ext}", ext,
+	)
+
+	return r.Replace(pattern)
+}
@@ -1 +1,9 @@
+DELETE from task_results;
+DELETE from task_progress;
+DELETE from task_events;
+DELETE from pipeline_runs;
+DELETE from task_dependencies;
+DELETE from schedules;
+DELETE from idempotency_keys;
+DELETE from dead_letters;
 DELETE from tasks;
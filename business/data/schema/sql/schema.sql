@@ -11,4 +11,254 @@ CREATE TABLE tasks (
 	timeout         INT,
 
     PRIMARY KEY (task_id)
-);
\ No newline at end of file
+);
+
+-- Version:1.2
+-- Description: Add task lifecycle columns
+ALTER TABLE tasks
+	ADD COLUMN status       TEXT      NOT NULL DEFAULT 'pending',
+	ADD COLUMN started_at   TIMESTAMP NULL,
+	ADD COLUMN finished_at  TIMESTAMP NULL,
+	ADD COLUMN attempt      INT       NOT NULL DEFAULT 0,
+	ADD CONSTRAINT tasks_status_check
+		CHECK (status IN ('pending', 'claimed', 'running', 'succeeded', 'failed', 'cancelled'));
+
+-- Version:1.3
+-- Description: Add work queue claiming columns
+ALTER TABLE tasks
+	ADD COLUMN worker_id    TEXT      NULL,
+	ADD COLUMN claimed_at   TIMESTAMP NULL;
+
+CREATE INDEX tasks_pending_idx ON tasks (date_created) WHERE status = 'pending';
+
+-- Version:1.4
+-- Description: Add task lease expiry
+ALTER TABLE tasks
+	ADD COLUMN lease_expires_at TIMESTAMP NULL;
+
+CREATE INDEX tasks_lease_idx ON tasks (lease_expires_at) WHERE status IN ('claimed', 'running');
+
+-- Version:1.5
+-- Description: Create table dead_letters
+ALTER TABLE tasks
+	ADD COLUMN last_error TEXT NULL;
+
+CREATE TABLE dead_letters (
+	task_id         UUID      NOT NULL,
+	queue           TEXT      NOT NULL,
+	last_error      TEXT      NOT NULL,
+	attempts        INT       NOT NULL,
+	worker_id       TEXT      NULL,
+	date_created    TIMESTAMP NOT NULL,
+
+	PRIMARY KEY (task_id),
+	FOREIGN KEY (task_id) REFERENCES tasks (task_id) ON DELETE CASCADE
+);
+
+-- Version:1.6
+-- Description: Add task retry policy and backoff
+ALTER TABLE tasks
+	ADD COLUMN max_attempts     INT              NOT NULL DEFAULT 0,
+	ADD COLUMN backoff_base     BIGINT           NOT NULL DEFAULT 0,
+	ADD COLUMN backoff_max      BIGINT           NOT NULL DEFAULT 0,
+	ADD COLUMN backoff_jitter   DOUBLE PRECISION NOT NULL DEFAULT 0,
+	ADD COLUMN not_before       TIMESTAMP        NULL;
+
+-- Version:1.7
+-- Description: Widen task timeout to hold a nanosecond duration
+ALTER TABLE tasks
+	ALTER COLUMN timeout TYPE BIGINT;
+
+-- Version:1.8
+-- Description: Record the template that produced each task
+ALTER TABLE tasks
+	ADD COLUMN template      TEXT NOT NULL DEFAULT '',
+	ADD COLUMN match_reason  TEXT NOT NULL DEFAULT '';
+
+-- Version:1.9
+-- Description: Group the tasks a fan-out template creates
+ALTER TABLE tasks
+	ADD COLUMN group_id UUID NULL;
+
+CREATE INDEX tasks_group_idx ON tasks (group_id) WHERE group_id IS NOT NULL;
+
+-- Version:2.1
+-- Description: Keep the submission details of each task
+ALTER TABLE tasks
+	ADD COLUMN priority      INT   NOT NULL DEFAULT 0,
+	ADD COLUMN labels        JSONB NOT NULL DEFAULT '{}',
+	ADD COLUMN callback_url  TEXT  NULL,
+	ADD COLUMN parameters    JSONB NOT NULL DEFAULT '{}';
+
+-- Version:2.2
+-- Description: Index the task listing filters and sort keys
+CREATE INDEX tasks_created_idx ON tasks (date_created, task_id);
+CREATE INDEX tasks_priority_idx ON tasks (priority, task_id);
+CREATE INDEX tasks_status_idx ON tasks (status, date_created);
+CREATE INDEX tasks_labels_idx ON tasks USING GIN (labels);
+CREATE INDEX tasks_input_url_idx ON tasks (input_url text_pattern_ops);
+
+-- Version:2.3
+-- Description: Add idempotency keys for task submission
+CREATE TABLE idempotency_keys (
+	idempotency_key TEXT,
+	request_hash    TEXT      NOT NULL,
+	task_id         UUID      NULL,
+	date_created    TIMESTAMP NOT NULL,
+
+	PRIMARY KEY (idempotency_key)
+);
+
+CREATE INDEX idempotency_keys_created_idx ON idempotency_keys (date_created);
+
+-- Version:2.4
+-- Description: Deduplicate tasks per resource, template and build
+ALTER TABLE tasks
+	ADD COLUMN dedup_key TEXT NULL;
+
+CREATE UNIQUE INDEX tasks_dedup_idx ON tasks (dedup_key)
+	WHERE dedup_key IS NOT NULL AND status NOT IN ('failed', 'cancelled');
+
+-- Version:2.5
+-- Description: Add recurring task schedules
+CREATE TABLE schedules (
+	schedule_id  UUID,
+	name         TEXT      NOT NULL,
+	cron         TEXT      NOT NULL,
+	resource     TEXT      NOT NULL,
+	prefix       BOOLEAN   NOT NULL DEFAULT FALSE,
+	template     TEXT      NOT NULL DEFAULT '',
+	force        BOOLEAN   NOT NULL DEFAULT FALSE,
+	enabled      BOOLEAN   NOT NULL DEFAULT TRUE,
+	next_run     TIMESTAMP NULL,
+	last_run     TIMESTAMP NULL,
+	date_created TIMESTAMP NOT NULL,
+	date_updated TIMESTAMP NOT NULL,
+
+	PRIMARY KEY (schedule_id)
+);
+
+CREATE INDEX schedules_due_idx ON schedules (next_run) WHERE enabled;
+
+-- Version:2.6
+-- Description: Add task dependencies
+CREATE TABLE task_dependencies (
+	task_id    UUID NOT NULL REFERENCES tasks (task_id) ON DELETE CASCADE,
+	depends_on UUID NOT NULL REFERENCES tasks (task_id) ON DELETE CASCADE,
+
+	PRIMARY KEY (task_id, depends_on)
+);
+
+CREATE INDEX task_dependencies_parent_idx ON task_dependencies (depends_on);
+
+-- Version:2.7
+-- Description: Add pipeline runs
+CREATE TABLE pipeline_runs (
+	run_id       UUID,
+	pipeline     TEXT      NOT NULL,
+	input_url    TEXT      NOT NULL,
+	labels       JSONB     NOT NULL DEFAULT '{}',
+	stage        INT       NOT NULL DEFAULT 0,
+	task_id      UUID      NULL REFERENCES tasks (task_id) ON DELETE SET NULL,
+	task_ids     TEXT[]    NOT NULL DEFAULT '{}',
+	status       TEXT      NOT NULL,
+	last_error   TEXT      NULL,
+	date_created TIMESTAMP NOT NULL,
+	date_updated TIMESTAMP NOT NULL,
+
+	PRIMARY KEY (run_id),
+	CONSTRAINT pipeline_runs_status_check
+		CHECK (status IN ('running', 'succeeded', 'failed', 'cancelled'))
+);
+
+CREATE INDEX pipeline_runs_task_idx ON pipeline_runs (task_id) WHERE status = 'running';
+CREATE INDEX pipeline_runs_pipeline_idx ON pipeline_runs (pipeline, date_created);
+
+-- Version:2.8
+-- Description: Add task cancellation requests
+ALTER TABLE tasks
+	ADD COLUMN cancel_requested_at TIMESTAMP NULL;
+
+-- Version:2.9
+-- Description: Soft delete tasks into a trash
+ALTER TABLE tasks
+	ADD COLUMN deleted_at TIMESTAMP NULL;
+
+CREATE INDEX tasks_deleted_idx ON tasks (deleted_at) WHERE deleted_at IS NOT NULL;
+
+DROP INDEX tasks_dedup_idx;
+CREATE UNIQUE INDEX tasks_dedup_idx ON tasks (dedup_key)
+	WHERE dedup_key IS NOT NULL AND status NOT IN ('failed', 'cancelled') AND deleted_at IS NULL;
+
+-- Version:3.1
+-- Description: Add task event history
+CREATE TABLE task_events (
+	event_id     UUID,
+	task_id      UUID      NOT NULL REFERENCES tasks (task_id) ON DELETE CASCADE,
+	type         TEXT      NOT NULL,
+	from_status  TEXT      NULL,
+	to_status    TEXT      NULL,
+	actor        TEXT      NOT NULL,
+	trace_id     TEXT      NOT NULL,
+	message      TEXT      NULL,
+	date_created TIMESTAMP NOT NULL,
+
+	PRIMARY KEY (event_id)
+);
+
+CREATE INDEX task_events_task_idx ON task_events (task_id, date_created);
+
+-- Version:3.2
+-- Description: Add worker progress reports
+ALTER TABLE tasks
+	ADD COLUMN progress JSONB NULL;
+
+CREATE TABLE task_progress (
+	progress_id  UUID,
+	task_id      UUID             NOT NULL REFERENCES tasks (task_id) ON DELETE CASCADE,
+	worker_id    TEXT             NOT NULL,
+	attempt      INT              NOT NULL,
+	percent      DOUBLE PRECISION NOT NULL,
+	stage        TEXT             NOT NULL,
+	bytes        BIGINT           NOT NULL,
+	message      TEXT             NOT NULL,
+	date_created TIMESTAMP        NOT NULL,
+
+	PRIMARY KEY (progress_id)
+);
+
+CREATE INDEX task_progress_task_idx ON task_progress (task_id, date_created);
+
+-- Version:3.3
+-- Description: Add task result manifests
+CREATE TABLE task_results (
+	result_id    UUID,
+	task_id      UUID      NOT NULL REFERENCES tasks (task_id) ON DELETE CASCADE,
+	url          TEXT      NOT NULL,
+	size         BIGINT    NOT NULL,
+	checksum     TEXT      NOT NULL,
+	content_type TEXT      NOT NULL,
+	metadata     JSONB     NOT NULL DEFAULT '{}',
+	date_created TIMESTAMP NOT NULL,
+
+	PRIMARY KEY (result_id),
+	UNIQUE (task_id, url)
+);
+
+-- Version:3.4
+-- Description: Tell a retry setting left unset from one set to zero
+ALTER TABLE tasks
+	ALTER COLUMN max_attempts   DROP NOT NULL,
+	ALTER COLUMN max_attempts   DROP DEFAULT,
+	ALTER COLUMN backoff_base   DROP NOT NULL,
+	ALTER COLUMN backoff_base   DROP DEFAULT,
+	ALTER COLUMN backoff_max    DROP NOT NULL,
+	ALTER COLUMN backoff_max    DROP DEFAULT,
+	ALTER COLUMN backoff_jitter DROP NOT NULL,
+	ALTER COLUMN backoff_jitter DROP DEFAULT;
+
+UPDATE tasks SET
+	max_attempts   = NULLIF(max_attempts, 0),
+	backoff_base   = NULLIF(backoff_base, 0),
+	backoff_max    = NULLIF(backoff_max, 0),
+	backoff_jitter = NULLIF(backoff_jitter, 0);
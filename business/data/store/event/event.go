@@ -0,0 +1,129 @@
+// Package event provides access to the history of what happened to each task
+package event
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jnkroeker/khyme/business/data/store/task"
+	"github.com/jnkroeker/khyme/business/sys/database"
+	"github.com/jnkroeker/khyme/business/sys/validate"
+	"github.com/jnkroeker/khyme/foundation/web"
+	"go.uber.org/zap"
+)
+
+// Store manages the set of APIs for task event access
+type Store struct {
+	log          *zap.SugaredLogger
+	tr           database.Transactor
+	db           sqlx.ExtContext
+	isWithinTran bool
+}
+
+func NewStore(log *zap.SugaredLogger, db *sqlx.DB) Store {
+	return Store{
+		log: log,
+		tr:  db,
+		db:  db,
+	}
+}
+
+// WithinTran runs fn inside a transaction. If the Store is already
+// bound to a transaction, fn joins it instead of starting a new one.
+func (s Store) WithinTran(ctx context.Context, fn func(sqlx.ExtContext) error) error {
+	if s.isWithinTran {
+		return fn(s.db)
+	}
+	return database.WithinTran(ctx, s.log, s.tr, fn)
+}
+
+// Tran returns a copy of the Store bound to the provided transaction
+func (s Store) Tran(tx sqlx.ExtContext) Store {
+	return Store{
+		log:          s.log,
+		tr:           s.tr,
+		db:           tx,
+		isWithinTran: true,
+	}
+}
+
+// Record stores an event, stamped with the trace id of the request in ctx
+func (s Store) Record(ctx context.Context, ne NewEvent, now time.Time) (Event, error) {
+	e := Event{
+		ID:          validate.GenerateID(),
+		TaskID:      ne.TaskID,
+		Type:        ne.Type,
+		Actor:       ne.Actor,
+		TraceID:     web.GetTraceId(ctx),
+		DateCreated: now,
+	}
+	if ne.From != "" {
+		e.From = &ne.From
+	}
+	if ne.To != "" {
+		e.To = &ne.To
+	}
+	if ne.Message != "" {
+		e.Message = &ne.Message
+	}
+
+	const q = `INSERT INTO task_events
+						(event_id, task_id, type, from_status, to_status, actor, trace_id, message, date_created)
+				VALUES
+						(:event_id, :task_id, :type, :from_status, :to_status, :actor, :trace_id, :message, :date_created)`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, e); err != nil {
+		return Event{}, fmt.Errorf("inserting event: %w", err)
+	}
+
+	return e, nil
+}
+
+// RecordStatus stores a status_changed event for each task, moving it from
+// its previous status to the status it holds now
+func (s Store) RecordStatus(ctx context.Context, tasks []task.Task, from task.Status, actor string, message string, now time.Time) error {
+	for _, t := range tasks {
+		ne := NewEvent{
+			TaskID:  t.ID,
+			Type:    TypeStatusChanged,
+			From:    from,
+			To:      t.Status,
+			Actor:   actor,
+			Message: message,
+		}
+		if _, err := s.Record(ctx, ne, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// QueryByTask returns the history of a task, oldest first
+func (s Store) QueryByTask(ctx context.Context, taskID string) ([]Event, error) {
+	data := struct {
+		TaskID string `db:"task_id"`
+	}{
+		TaskID: taskID,
+	}
+
+	const q = `SELECT * FROM task_events WHERE task_id = :task_id ORDER BY date_created, event_id`
+
+	var events []Event
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &events); err != nil {
+		return nil, fmt.Errorf("selecting events of task[%s]: %w", taskID, err)
+	}
+
+	return events, nil
+}
+
+// Caller returns the actor doing work in ctx: the api when ctx carries a
+// request, the system when it does not
+func Caller(ctx context.Context) string {
+	if _, err := web.GetValues(ctx); err == nil {
+		return ActorAPI
+	}
+	return ActorSystem
+}
@@ -0,0 +1,62 @@
+package event
+
+import (
+	"time"
+
+	"github.com/jnkroeker/khyme/business/data/store/task"
+)
+
+// Set of event types recorded against a task
+const (
+	TypeCreated         = "created"
+	TypeStatusChanged   = "status_changed"
+	TypeClaimed         = "claimed"
+	TypeHeartbeatGap    = "heartbeat_gap"
+	TypeFailed          = "failed"
+	TypeRetryScheduled  = "retry_scheduled"
+	TypeDeadLettered    = "dead_lettered"
+	TypeRequeued        = "requeued"
+	TypeLeaseExpired    = "lease_expired"
+	TypeCancelRequested = "cancel_requested"
+	TypePriorityChanged = "priority_changed"
+	TypeReleased        = "released"
+	TypeDeleted         = "deleted"
+	TypeRestored        = "restored"
+)
+
+// Set of actors that are not workers
+const (
+	ActorAPI    = "api"
+	ActorReaper = "reaper"
+	ActorSystem = "system"
+)
+
+// Worker returns the actor for the worker with the given id
+func Worker(workerID string) string {
+	return "worker:" + workerID
+}
+
+// Event is one entry in the history of a task: what happened, who did it,
+// and the request it happened in. From and To are set when the status of
+// the task changed.
+type Event struct {
+	ID          string       `db:"event_id" json:"id"`
+	TaskID      string       `db:"task_id" json:"task_id"`
+	Type        string       `db:"type" json:"type"`
+	From        *task.Status `db:"from_status" json:"from,omitempty"`
+	To          *task.Status `db:"to_status" json:"to,omitempty"`
+	Actor       string       `db:"actor" json:"actor"`
+	TraceID     string       `db:"trace_id" json:"trace_id"`
+	Message     *string      `db:"message" json:"message,omitempty"`
+	DateCreated time.Time    `db:"date_created" json:"date_created"`
+}
+
+// NewEvent contains what a caller records about something that happened to a task
+type NewEvent struct {
+	TaskID  string
+	Type    string
+	From    task.Status
+	To      task.Status
+	Actor   string
+	Message string
+}
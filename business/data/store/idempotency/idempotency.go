@@ -0,0 +1,148 @@
+// Package idempotency provides access to the keys callers send to make
+// a task submission safe to retry
+package idempotency
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jnkroeker/khyme/business/sys/database"
+	"go.uber.org/zap"
+)
+
+// Store manages the set of APIs for idempotency key access
+type Store struct {
+	log          *zap.SugaredLogger
+	tr           database.Transactor
+	db           sqlx.ExtContext
+	isWithinTran bool
+}
+
+func NewStore(log *zap.SugaredLogger, db *sqlx.DB) Store {
+	return Store{
+		log: log,
+		tr:  db,
+		db:  db,
+	}
+}
+
+// WithinTran runs fn inside a transaction. If the Store is already
+// bound to a transaction, fn joins it instead of starting a new one.
+func (s Store) WithinTran(ctx context.Context, fn func(sqlx.ExtContext) error) error {
+	if s.isWithinTran {
+		return fn(s.db)
+	}
+	return database.WithinTran(ctx, s.log, s.tr, fn)
+}
+
+// Tran returns a copy of the Store bound to the provided transaction
+func (s Store) Tran(tx sqlx.ExtContext) Store {
+	return Store{
+		log:          s.log,
+		tr:           s.tr,
+		db:           tx,
+		isWithinTran: true,
+	}
+}
+
+// Reserve records key against a submission. It reports false when the key is
+// already held by a submission made after expiredBefore; an older holder,
+// or one whose task has since been deleted, is replaced. A concurrent reservation of the same key waits for this
+// one's transaction to finish.
+func (s Store) Reserve(ctx context.Context, key string, requestHash string, now time.Time, expiredBefore time.Time) (bool, error) {
+	data := struct {
+		Key           string    `db:"idempotency_key"`
+		RequestHash   string    `db:"request_hash"`
+		Now           time.Time `db:"now"`
+		ExpiredBefore time.Time `db:"expired_before"`
+	}{
+		Key:           key,
+		RequestHash:   requestHash,
+		Now:           now,
+		ExpiredBefore: expiredBefore,
+	}
+
+	const q = `INSERT INTO idempotency_keys
+						(idempotency_key, request_hash, task_id, date_created)
+				VALUES
+						(:idempotency_key, :request_hash, NULL, :now)
+				ON CONFLICT (idempotency_key) DO UPDATE
+				SET
+					request_hash = EXCLUDED.request_hash,
+					task_id = NULL,
+					date_created = EXCLUDED.date_created
+				WHERE
+					idempotency_keys.date_created < :expired_before OR
+					(idempotency_keys.task_id IS NOT NULL AND NOT EXISTS (
+						SELECT 1 FROM tasks
+						WHERE tasks.task_id = idempotency_keys.task_id AND tasks.deleted_at IS NULL
+					))
+				RETURNING *`
+
+	var keys []Key
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &keys); err != nil {
+		return false, fmt.Errorf("reserving idempotency key: %w", err)
+	}
+
+	return len(keys) == 1, nil
+}
+
+// SetTask records the task created by the submission holding key
+func (s Store) SetTask(ctx context.Context, key string, taskID string) error {
+	data := struct {
+		Key    string `db:"idempotency_key"`
+		TaskID string `db:"task_id"`
+	}{
+		Key:    key,
+		TaskID: taskID,
+	}
+
+	const q = `UPDATE idempotency_keys SET task_id = :task_id WHERE idempotency_key = :idempotency_key`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("updating idempotency key: %w", err)
+	}
+
+	return nil
+}
+
+// QueryByKey gets the specified idempotency key from the database
+func (s Store) QueryByKey(ctx context.Context, key string) (Key, error) {
+	data := struct {
+		Key string `db:"idempotency_key"`
+	}{
+		Key: key,
+	}
+
+	const q = `SELECT * FROM idempotency_keys WHERE idempotency_key = :idempotency_key`
+
+	var k Key
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &k); err != nil {
+		if err == database.ErrNotFound {
+			return Key{}, database.ErrNotFound
+		}
+		return Key{}, fmt.Errorf("selecting idempotency key: %w", err)
+	}
+
+	return k, nil
+}
+
+// Purge removes the keys recorded before expiredBefore, returning how many went
+func (s Store) Purge(ctx context.Context, expiredBefore time.Time) (int, error) {
+	data := struct {
+		ExpiredBefore time.Time `db:"expired_before"`
+	}{
+		ExpiredBefore: expiredBefore,
+	}
+
+	const q = `DELETE FROM idempotency_keys WHERE date_created < :expired_before RETURNING *`
+
+	var keys []Key
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &keys); err != nil {
+		return 0, fmt.Errorf("purging idempotency keys: %w", err)
+	}
+
+	return len(keys), nil
+}
@@ -0,0 +1,12 @@
+package idempotency
+
+import "time"
+
+// Key records the submission an Idempotency-Key was first used with
+// and the task that submission created.
+type Key struct {
+	Key         string    `db:"idempotency_key"`
+	RequestHash string    `db:"request_hash"`
+	TaskID      *string   `db:"task_id"`
+	DateCreated time.Time `db:"date_created"`
+}
@@ -0,0 +1,39 @@
+package pipeline
+
+import (
+	"time"
+
+	"github.com/jnkroeker/khyme/business/data/store/task"
+	"github.com/jnkroeker/khyme/business/sys/validate"
+	"github.com/lib/pq"
+)
+
+// Run is one resource making its way through the stages of a pipeline.
+// TaskID is the task of the current stage and Tasks holds the task of
+// every stage reached so far, in order. A run is running until its last
+// stage succeeds or a stage fails or is cancelled.
+type Run struct {
+	ID            string         `db:"run_id" json:"id"`
+	Pipeline      string         `db:"pipeline" json:"pipeline"`
+	InputResource string         `db:"input_url" json:"input_url"`
+	Labels        task.Labels    `db:"labels" json:"labels,omitempty"`
+	Stage         int            `db:"stage" json:"stage"`
+	TaskID        *string        `db:"task_id" json:"task_id,omitempty"`
+	Tasks         pq.StringArray `db:"task_ids" json:"task_ids"`
+	Status        task.Status    `db:"status" json:"status"`
+	LastError     *string        `db:"last_error" json:"last_error,omitempty"`
+	DateCreated   time.Time      `db:"date_created" json:"date_created"`
+	DateUpdated   time.Time      `db:"date_updated" json:"date_updated"`
+}
+
+// NewRun contains what a caller submits to start a pipeline on a resource.
+// Labels are set on the task of every stage.
+type NewRun struct {
+	InputResource string      `json:"input_url" validate:"required,url"`
+	Labels        task.Labels `json:"labels,omitempty" validate:"omitempty,max=32,dive,keys,required,max=63,endkeys,max=255"`
+}
+
+// Validate checks the request against its validate tags
+func (nr NewRun) Validate() error {
+	return validate.Check(nr)
+}
@@ -0,0 +1,172 @@
+// Package pipeline provides access to the runs of multi-stage pipelines
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jnkroeker/khyme/business/data/store/task"
+	"github.com/jnkroeker/khyme/business/sys/database"
+	"go.uber.org/zap"
+)
+
+// Store manages the set of APIs for pipeline run access
+type Store struct {
+	log          *zap.SugaredLogger
+	tr           database.Transactor
+	db           sqlx.ExtContext
+	isWithinTran bool
+}
+
+func NewStore(log *zap.SugaredLogger, db *sqlx.DB) Store {
+	return Store{
+		log: log,
+		tr:  db,
+		db:  db,
+	}
+}
+
+// WithinTran runs fn inside a transaction. If the Store is already
+// bound to a transaction, fn joins it instead of starting a new one.
+func (s Store) WithinTran(ctx context.Context, fn func(sqlx.ExtContext) error) error {
+	if s.isWithinTran {
+		return fn(s.db)
+	}
+	return database.WithinTran(ctx, s.log, s.tr, fn)
+}
+
+// Tran returns a copy of the Store bound to the provided transaction
+func (s Store) Tran(tx sqlx.ExtContext) Store {
+	return Store{
+		log:          s.log,
+		tr:           s.tr,
+		db:           tx,
+		isWithinTran: true,
+	}
+}
+
+func (s Store) Create(ctx context.Context, run Run) error {
+	const q = `INSERT INTO pipeline_runs
+						(run_id, pipeline, input_url, labels, stage, task_id, task_ids, status, last_error, date_created, date_updated)
+				VALUES
+						(:run_id, :pipeline, :input_url, :labels, :stage, :task_id, :task_ids, :status, :last_error, :date_created, :date_updated)`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, run); err != nil {
+		return fmt.Errorf("inserting pipeline run: %w", err)
+	}
+
+	return nil
+}
+
+// Update replaces the progress of the stored run with that of run
+func (s Store) Update(ctx context.Context, run Run) error {
+	const q = `UPDATE pipeline_runs SET
+					stage = :stage, task_id = :task_id, task_ids = :task_ids, status = :status,
+					last_error = :last_error, date_updated = :date_updated
+				WHERE run_id = :run_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, run); err != nil {
+		return fmt.Errorf("updating pipeline run[%s]: %w", run.ID, err)
+	}
+
+	return nil
+}
+
+// Stop ends the running run whose current stage is taskID with status,
+// recording reason as its last error. A task that is not the current
+// stage of a running run is ignored.
+func (s Store) Stop(ctx context.Context, taskID string, status task.Status, reason string, now time.Time) error {
+	data := struct {
+		TaskID  string      `db:"task_id"`
+		Status  task.Status `db:"status"`
+		Reason  string      `db:"reason"`
+		Now     time.Time   `db:"now"`
+		Running task.Status `db:"running"`
+	}{
+		TaskID:  taskID,
+		Status:  status,
+		Reason:  reason,
+		Now:     now,
+		Running: task.StatusRunning,
+	}
+
+	const q = `UPDATE pipeline_runs SET
+					status = :status, last_error = :reason, date_updated = :now
+				WHERE task_id = :task_id AND status = :running`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("stopping pipeline run of task[%s]: %w", taskID, err)
+	}
+
+	return nil
+}
+
+// QueryByID gets the specified run from the database
+func (s Store) QueryByID(ctx context.Context, runID string) (Run, error) {
+	data := struct {
+		RunID string `db:"run_id"`
+	}{
+		RunID: runID,
+	}
+
+	const q = `SELECT * FROM pipeline_runs WHERE run_id = :run_id`
+
+	var run Run
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &run); err != nil {
+		if err == database.ErrNotFound {
+			return Run{}, database.ErrNotFound
+		}
+		return Run{}, fmt.Errorf("selecting pipeline run[%s]: %w", runID, err)
+	}
+
+	return run, nil
+}
+
+// QueryByTask gets the running run whose current stage is taskID,
+// locking it until the transaction the Store is bound to ends.
+func (s Store) QueryByTask(ctx context.Context, taskID string) (Run, error) {
+	data := struct {
+		TaskID  string      `db:"task_id"`
+		Running task.Status `db:"running"`
+	}{
+		TaskID:  taskID,
+		Running: task.StatusRunning,
+	}
+
+	const q = `SELECT * FROM pipeline_runs WHERE task_id = :task_id AND status = :running FOR UPDATE`
+
+	var run Run
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &run); err != nil {
+		if err == database.ErrNotFound {
+			return Run{}, database.ErrNotFound
+		}
+		return Run{}, fmt.Errorf("selecting pipeline run of task[%s]: %w", taskID, err)
+	}
+
+	return run, nil
+}
+
+// QueryByPipeline returns a page of the runs of a pipeline, newest first
+func (s Store) QueryByPipeline(ctx context.Context, pipeline string, pageNumber int, rowsPerPage int) ([]Run, error) {
+	data := struct {
+		Pipeline    string `db:"pipeline"`
+		Offset      int    `db:"offset"`
+		RowsPerPage int    `db:"rows_per_page"`
+	}{
+		Pipeline:    pipeline,
+		Offset:      (pageNumber - 1) * rowsPerPage,
+		RowsPerPage: rowsPerPage,
+	}
+
+	const q = `SELECT * FROM pipeline_runs WHERE pipeline = :pipeline
+				ORDER BY date_created DESC, run_id OFFSET :offset ROWS FETCH NEXT :rows_per_page ROWS ONLY`
+
+	var runs []Run
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &runs); err != nil {
+		return nil, fmt.Errorf("selecting runs of pipeline[%s]: %w", pipeline, err)
+	}
+
+	return runs, nil
+}
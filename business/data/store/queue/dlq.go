@@ -0,0 +1,106 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jnkroeker/khyme/business/data/store/task"
+	"github.com/jnkroeker/khyme/business/sys/database"
+)
+
+// DeadLetter moves a failed task onto the named dead-letter queue,
+// preserving its last error, attempt count and the worker that last ran it.
+func (s Store) DeadLetter(ctx context.Context, t task.Task, queue string, now time.Time) (DeadLetter, error) {
+	dl := DeadLetter{
+		TaskID:      t.ID,
+		Queue:       queue,
+		Attempts:    t.Attempt,
+		WorkerID:    t.WorkerID,
+		DateCreated: now,
+	}
+	if t.LastError != nil {
+		dl.LastError = *t.LastError
+	}
+
+	const q = `INSERT INTO dead_letters
+						(task_id, queue, last_error, attempts, worker_id, date_created)
+				VALUES
+						(:task_id, :queue, :last_error, :attempts, :worker_id, :date_created)`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, dl); err != nil {
+		return DeadLetter{}, fmt.Errorf("inserting dead letter: %w", err)
+	}
+
+	dl.InputResource = t.InputResource
+	dl.ExecutionImage = t.ExecutionImage
+
+	return dl, nil
+}
+
+// QueryDeadLetters returns a page of the dead-letter queue, newest first
+func (s Store) QueryDeadLetters(ctx context.Context, pageNumber int, rowsPerPage int) ([]DeadLetter, error) {
+	data := struct {
+		Offset      int `db:"offset"`
+		RowsPerPage int `db:"rows_per_page"`
+	}{
+		Offset:      (pageNumber - 1) * rowsPerPage,
+		RowsPerPage: rowsPerPage,
+	}
+
+	const q = `SELECT
+					d.task_id, d.queue, d.last_error, d.attempts, d.worker_id, d.date_created,
+					t.input_url, t.exec_image
+				FROM dead_letters AS d
+				JOIN tasks AS t ON t.task_id = d.task_id AND t.deleted_at IS NULL
+				ORDER BY d.date_created DESC, d.task_id
+				OFFSET :offset ROWS FETCH NEXT :rows_per_page ROWS ONLY`
+
+	var dls []DeadLetter
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &dls); err != nil {
+		return nil, fmt.Errorf("selecting dead letters: %w", err)
+	}
+
+	return dls, nil
+}
+
+// RemoveDeadLetter takes a task off the dead-letter queue, returning
+// database.ErrNotFound if it was not on it.
+func (s Store) RemoveDeadLetter(ctx context.Context, taskID string) error {
+	data := struct {
+		TaskID string `db:"task_id"`
+	}{
+		TaskID: taskID,
+	}
+
+	const q = `DELETE FROM dead_letters AS d WHERE d.task_id = :task_id
+				AND EXISTS (SELECT 1 FROM tasks AS t WHERE t.task_id = d.task_id AND t.deleted_at IS NULL)
+				RETURNING d.task_id, d.queue, d.last_error, d.attempts, d.worker_id, d.date_created`
+
+	var dls []DeadLetter
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &dls); err != nil {
+		return fmt.Errorf("deleting dead letter: %w", err)
+	}
+	if len(dls) == 0 {
+		return database.ErrNotFound
+	}
+
+	return nil
+}
+
+// Requeue gives a dead-lettered task a fresh set of attempts
+func (s Store) Requeue(ctx context.Context, taskID string) error {
+	data := struct {
+		TaskID string `db:"task_id"`
+	}{
+		TaskID: taskID,
+	}
+
+	const q = `UPDATE tasks SET attempt = 0 WHERE task_id = :task_id AND deleted_at IS NULL`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("resetting attempts: %w", err)
+	}
+
+	return s.Retry(ctx, taskID, nil)
+}
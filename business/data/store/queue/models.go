@@ -0,0 +1,74 @@
+package queue
+
+import (
+	"time"
+
+	"github.com/jnkroeker/khyme/business/sys/validate"
+)
+
+// Claim contains the information a worker sends when asking for work
+type Claim struct {
+	WorkerID string `json:"worker_id" validate:"required"`
+	Max      int    `json:"max" validate:"gte=0"`
+}
+
+// Validate checks the request against its validate tags
+func (cl Claim) Validate() error {
+	return validate.Check(cl)
+}
+
+// Heartbeat contains the information a worker sends to keep its lease on a task
+type Heartbeat struct {
+	WorkerID string `json:"worker_id" validate:"required"`
+}
+
+// Validate checks the request against its validate tags
+func (hb Heartbeat) Validate() error {
+	return validate.Check(hb)
+}
+
+// Lease tells a worker how long it holds a task and how often to heartbeat.
+// Cancel tells it to stop the task and report it cancelled.
+type Lease struct {
+	TaskID            string        `json:"task_id"`
+	ExpiresAt         time.Time     `json:"expires_at"`
+	HeartbeatInterval time.Duration `json:"heartbeat_interval"`
+	Cancel            bool          `json:"cancel"`
+}
+
+// Progress contains the information a worker sends to report how far it has got with a task
+type Progress struct {
+	WorkerID string  `json:"worker_id" validate:"required"`
+	Percent  float64 `json:"percent" validate:"gte=0,lte=100"`
+	Stage    string  `json:"stage,omitempty" validate:"max=255"`
+	Bytes    int64   `json:"bytes,omitempty" validate:"gte=0"`
+	Message  string  `json:"message,omitempty" validate:"max=1024"`
+}
+
+// Validate checks the request against its validate tags
+func (p Progress) Validate() error {
+	return validate.Check(p)
+}
+
+// Failure contains the information a worker sends when a task run fails
+type Failure struct {
+	WorkerID string `json:"worker_id" validate:"required"`
+	Error    string `json:"error" validate:"required"`
+}
+
+// Validate checks the request against its validate tags
+func (f Failure) Validate() error {
+	return validate.Check(f)
+}
+
+// DeadLetter represents a task that exhausted its attempts and was set aside
+type DeadLetter struct {
+	TaskID         string    `db:"task_id" json:"task_id"`
+	Queue          string    `db:"queue" json:"queue"`
+	LastError      string    `db:"last_error" json:"last_error"`
+	Attempts       int       `db:"attempts" json:"attempts"`
+	WorkerID       *string   `db:"worker_id" json:"worker_id,omitempty"`
+	DateCreated    time.Time `db:"date_created" json:"date_created"`
+	InputResource  string    `db:"input_url" json:"input_url,omitempty"`
+	ExecutionImage string    `db:"exec_image" json:"exec_image,omitempty"`
+}
@@ -0,0 +1,314 @@
+// Package queue provides work queue access on top of the tasks table
+package queue
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jnkroeker/khyme/business/data/store/task"
+	"github.com/jnkroeker/khyme/business/sys/database"
+	"go.uber.org/zap"
+)
+
+// Store manages the set of APIs for work queue access
+type Store struct {
+	log          *zap.SugaredLogger
+	tr           database.Transactor
+	db           sqlx.ExtContext
+	isWithinTran bool
+}
+
+func NewStore(log *zap.SugaredLogger, db *sqlx.DB) Store {
+	return Store{
+		log: log,
+		tr:  db,
+		db:  db,
+	}
+}
+
+// WithinTran runs fn inside a transaction. If the Store is already
+// bound to a transaction, fn joins it instead of starting a new one.
+func (s Store) WithinTran(ctx context.Context, fn func(sqlx.ExtContext) error) error {
+	if s.isWithinTran {
+		return fn(s.db)
+	}
+	return database.WithinTran(ctx, s.log, s.tr, fn)
+}
+
+// Tran returns a copy of the Store bound to the provided transaction
+func (s Store) Tran(tx sqlx.ExtContext) Store {
+	return Store{
+		log:          s.log,
+		tr:           s.tr,
+		db:           tx,
+		isWithinTran: true,
+	}
+}
+
+// Claim atomically hands up to limit pending tasks to the worker, highest
+// priority first and oldest first within a priority. A waiting task gains one
+// point of priority for every aging interval it has waited, so low priority
+// work still moves under sustained load. An aging of zero turns this off.
+// A task that depends on others is only handed out once they all succeeded.
+// Rows locked by a concurrent claim are skipped rather than waited on,
+// so several workers can pull at once without receiving the same task.
+// Each claimed task is leased to the worker until leaseExpires.
+func (s Store) Claim(ctx context.Context, workerID string, limit int, now time.Time, leaseExpires time.Time, aging time.Duration) ([]task.Task, error) {
+	data := struct {
+		WorkerID     string      `db:"worker_id"`
+		Limit        int         `db:"limit"`
+		Now          time.Time   `db:"now"`
+		LeaseExpires time.Time   `db:"lease_expires"`
+		Aging        int64       `db:"aging"`
+		Pending      task.Status `db:"pending"`
+		Claimed      task.Status `db:"claimed"`
+		Succeeded    task.Status `db:"succeeded"`
+	}{
+		WorkerID:     workerID,
+		Limit:        limit,
+		Now:          now,
+		LeaseExpires: leaseExpires,
+		Aging:        int64(aging / time.Second),
+		Pending:      task.StatusPending,
+		Claimed:      task.StatusClaimed,
+		Succeeded:    task.StatusSucceeded,
+	}
+
+	const q = `UPDATE tasks SET
+					status = :claimed, worker_id = :worker_id, claimed_at = :now, attempt = attempt + 1,
+					lease_expires_at = :lease_expires, progress = NULL
+				WHERE task_id IN (
+					SELECT t.task_id FROM tasks AS t
+					WHERE t.status = :pending AND (t.not_before IS NULL OR t.not_before <= :now) AND t.deleted_at IS NULL
+					AND NOT EXISTS (
+						SELECT 1 FROM task_dependencies AS d
+						JOIN tasks AS p ON p.task_id = d.depends_on
+						WHERE d.task_id = t.task_id AND p.status <> :succeeded
+					)
+					ORDER BY
+						t.priority + CASE WHEN :aging > 0
+							THEN FLOOR(EXTRACT(EPOCH FROM (:now - t.date_created)) / :aging)
+							ELSE 0 END DESC,
+						t.date_created
+					LIMIT :limit
+					FOR UPDATE SKIP LOCKED
+				)
+				RETURNING *`
+
+	var tasks []task.Task
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &tasks); err != nil {
+		return nil, fmt.Errorf("claiming tasks: %w", err)
+	}
+
+	return tasks, nil
+}
+
+// Heartbeat extends the lease the worker holds on an active task. No task is
+// returned when the worker no longer holds the lease.
+func (s Store) Heartbeat(ctx context.Context, taskID string, workerID string, leaseExpires time.Time) ([]task.Task, error) {
+	data := struct {
+		TaskID       string      `db:"task_id"`
+		WorkerID     string      `db:"worker_id"`
+		LeaseExpires time.Time   `db:"lease_expires"`
+		Claimed      task.Status `db:"claimed"`
+		Running      task.Status `db:"running"`
+	}{
+		TaskID:       taskID,
+		WorkerID:     workerID,
+		LeaseExpires: leaseExpires,
+		Claimed:      task.StatusClaimed,
+		Running:      task.StatusRunning,
+	}
+
+	const q = `UPDATE tasks SET
+					lease_expires_at = :lease_expires
+				WHERE task_id = :task_id AND worker_id = :worker_id AND status IN (:claimed, :running) AND deleted_at IS NULL
+				RETURNING *`
+
+	var tasks []task.Task
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &tasks); err != nil {
+		return nil, fmt.Errorf("extending lease: %w", err)
+	}
+
+	return tasks, nil
+}
+
+// Progress stores the latest progress the worker holding the lease reported
+// on an active task. No task is returned when the worker no longer holds the lease.
+func (s Store) Progress(ctx context.Context, taskID string, workerID string, p task.Progress) ([]task.Task, error) {
+	data := struct {
+		TaskID   string        `db:"task_id"`
+		WorkerID string        `db:"worker_id"`
+		Progress task.Progress `db:"progress"`
+		Claimed  task.Status   `db:"claimed"`
+		Running  task.Status   `db:"running"`
+	}{
+		TaskID:   taskID,
+		WorkerID: workerID,
+		Progress: p,
+		Claimed:  task.StatusClaimed,
+		Running:  task.StatusRunning,
+	}
+
+	const q = `UPDATE tasks SET
+					progress = :progress
+				WHERE task_id = :task_id AND worker_id = :worker_id AND status IN (:claimed, :running) AND deleted_at IS NULL
+				RETURNING *`
+
+	var tasks []task.Task
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &tasks); err != nil {
+		return nil, fmt.Errorf("updating progress: %w", err)
+	}
+
+	return tasks, nil
+}
+
+// Fail records a failed run reported by the worker holding the lease.
+// A task the worker was asked to cancel is cancelled rather than failed.
+// No task is returned when the worker no longer holds the lease.
+func (s Store) Fail(ctx context.Context, taskID string, workerID string, lastError string, now time.Time) ([]task.Task, error) {
+	data := struct {
+		TaskID    string      `db:"task_id"`
+		WorkerID  string      `db:"worker_id"`
+		LastError string      `db:"last_error"`
+		Now       time.Time   `db:"now"`
+		Claimed   task.Status `db:"claimed"`
+		Running   task.Status `db:"running"`
+		Failed    task.Status `db:"failed"`
+		Cancelled task.Status `db:"cancelled"`
+	}{
+		TaskID:    taskID,
+		WorkerID:  workerID,
+		LastError: lastError,
+		Now:       now,
+		Claimed:   task.StatusClaimed,
+		Running:   task.StatusRunning,
+		Failed:    task.StatusFailed,
+		Cancelled: task.StatusCancelled,
+	}
+
+	const q = `UPDATE tasks SET
+					status = CASE WHEN cancel_requested_at IS NULL THEN :failed ELSE :cancelled END,
+					finished_at = :now, lease_expires_at = NULL, last_error = :last_error
+				WHERE task_id = :task_id AND worker_id = :worker_id AND status IN (:claimed, :running) AND deleted_at IS NULL
+				RETURNING *`
+
+	var tasks []task.Task
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &tasks); err != nil {
+		return nil, fmt.Errorf("failing task: %w", err)
+	}
+
+	return tasks, nil
+}
+
+// Retry puts a failed task back on the queue, keeping its attempt count
+// and last error so the next run can see what went wrong before.
+// The task cannot be claimed again until notBefore, when one is given.
+// database.ErrNotFound is returned when the task is not failed.
+func (s Store) Retry(ctx context.Context, taskID string, notBefore *time.Time) error {
+	data := struct {
+		TaskID    string      `db:"task_id"`
+		NotBefore *time.Time  `db:"not_before"`
+		Pending   task.Status `db:"pending"`
+		Failed    task.Status `db:"failed"`
+	}{
+		TaskID:    taskID,
+		NotBefore: notBefore,
+		Pending:   task.StatusPending,
+		Failed:    task.StatusFailed,
+	}
+
+	const q = `UPDATE tasks SET
+					status = :pending, worker_id = NULL, claimed_at = NULL, started_at = NULL, finished_at = NULL,
+					not_before = :not_before
+				WHERE task_id = :task_id AND status = :failed AND deleted_at IS NULL
+				RETURNING *`
+
+	var tasks []task.Task
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &tasks); err != nil {
+		return fmt.Errorf("retrying task: %w", err)
+	}
+	if len(tasks) == 0 {
+		return database.ErrNotFound
+	}
+
+	return nil
+}
+
+// Reap returns every active task whose lease expired before now to pending,
+// so that another worker can claim it. Tasks that have already used their
+// attempts are failed instead and returned separately, as are tasks that
+// were asked to be cancelled, which are cancelled. maxAttempts applies
+// to tasks whose own retry policy does not set a limit.
+func (s Store) Reap(ctx context.Context, now time.Time, maxAttempts int) (requeued []task.Task, failed []task.Task, cancelled []task.Task, err error) {
+	data := struct {
+		Now         time.Time   `db:"now"`
+		MaxAttempts int         `db:"default_max_attempts"`
+		LastError   string      `db:"last_error"`
+		Pending     task.Status `db:"pending"`
+		Claimed     task.Status `db:"claimed"`
+		Running     task.Status `db:"running"`
+		Failed      task.Status `db:"failed"`
+		Cancelled   task.Status `db:"cancelled"`
+	}{
+		Now:         now,
+		MaxAttempts: maxAttempts,
+		LastError:   "lease expired",
+		Pending:     task.StatusPending,
+		Claimed:     task.StatusClaimed,
+		Running:     task.StatusRunning,
+		Failed:      task.StatusFailed,
+		Cancelled:   task.StatusCancelled,
+	}
+
+	const cancel = `UPDATE tasks SET
+					status = :cancelled, finished_at = :now, lease_expires_at = NULL, last_error = :last_error
+				WHERE task_id IN (
+					SELECT task_id FROM tasks
+					WHERE status IN (:claimed, :running) AND lease_expires_at < :now AND deleted_at IS NULL
+						AND cancel_requested_at IS NOT NULL
+					FOR UPDATE SKIP LOCKED
+				)
+				RETURNING *`
+
+	const fail = `UPDATE tasks SET
+					status = :failed, finished_at = :now, lease_expires_at = NULL, last_error = :last_error
+				WHERE task_id IN (
+					SELECT task_id FROM tasks
+					WHERE status IN (:claimed, :running) AND lease_expires_at < :now AND deleted_at IS NULL
+						AND attempt >= COALESCE(max_attempts, :default_max_attempts)
+					FOR UPDATE SKIP LOCKED
+				)
+				RETURNING *`
+
+	const requeue = `UPDATE tasks SET
+					status = :pending, worker_id = NULL, claimed_at = NULL, started_at = NULL, lease_expires_at = NULL,
+					last_error = :last_error
+				WHERE task_id IN (
+					SELECT task_id FROM tasks
+					WHERE status IN (:claimed, :running) AND lease_expires_at < :now AND deleted_at IS NULL
+					FOR UPDATE SKIP LOCKED
+				)
+				RETURNING *`
+
+	tran := func(tx sqlx.ExtContext) error {
+		if err := database.NamedQuerySlice(ctx, s.log, tx, cancel, data, &cancelled); err != nil {
+			return fmt.Errorf("cancelling tasks: %w", err)
+		}
+		if err := database.NamedQuerySlice(ctx, s.log, tx, fail, data, &failed); err != nil {
+			return fmt.Errorf("failing exhausted tasks: %w", err)
+		}
+		if err := database.NamedQuerySlice(ctx, s.log, tx, requeue, data, &requeued); err != nil {
+			return fmt.Errorf("requeueing tasks: %w", err)
+		}
+		return nil
+	}
+
+	if err := s.WithinTran(ctx, tran); err != nil {
+		return nil, nil, nil, fmt.Errorf("reaping tasks: %w", err)
+	}
+
+	return requeued, failed, cancelled, nil
+}
@@ -0,0 +1,60 @@
+package schedule
+
+import (
+	"time"
+
+	"github.com/jnkroeker/khyme/business/sys/validate"
+)
+
+// Schedule submits a resource for processing on a recurring cron schedule.
+// When Prefix is set the resource names a bucket prefix and every object
+// under it is submitted.
+type Schedule struct {
+	ID          string     `db:"schedule_id" json:"id"`
+	Name        string     `db:"name" json:"name"`
+	Cron        string     `db:"cron" json:"cron"`
+	Resource    string     `db:"resource" json:"resource"`
+	Prefix      bool       `db:"prefix" json:"prefix"`
+	Template    string     `db:"template" json:"template,omitempty"`
+	Force       bool       `db:"force" json:"force"`
+	Enabled     bool       `db:"enabled" json:"enabled"`
+	NextRun     *time.Time `db:"next_run" json:"next_run,omitempty"`
+	LastRun     *time.Time `db:"last_run" json:"last_run,omitempty"`
+	DateCreated time.Time  `db:"date_created" json:"date_created"`
+	DateUpdated time.Time  `db:"date_updated" json:"date_updated"`
+}
+
+// NewSchedule contains what a caller submits to create a Schedule.
+// Template names a template to use instead of matching on each resource,
+// and Force creates tasks for resources already processed.
+type NewSchedule struct {
+	Name     string `json:"name" validate:"required"`
+	Cron     string `json:"cron" validate:"required"`
+	Resource string `json:"resource" validate:"required,url"`
+	Prefix   bool   `json:"prefix"`
+	Template string `json:"template,omitempty"`
+	Force    bool   `json:"force,omitempty"`
+	Enabled  *bool  `json:"enabled,omitempty"`
+}
+
+// Validate checks the request against its validate tags
+func (ns NewSchedule) Validate() error {
+	return validate.Check(ns)
+}
+
+// UpdateSchedule contains the fields of a Schedule a caller may change.
+// Fields left out are kept.
+type UpdateSchedule struct {
+	Name     *string `json:"name,omitempty" validate:"omitempty,min=1"`
+	Cron     *string `json:"cron,omitempty" validate:"omitempty,min=1"`
+	Resource *string `json:"resource,omitempty" validate:"omitempty,url"`
+	Prefix   *bool   `json:"prefix,omitempty"`
+	Template *string `json:"template,omitempty"`
+	Force    *bool   `json:"force,omitempty"`
+	Enabled  *bool   `json:"enabled,omitempty"`
+}
+
+// Validate checks the request against its validate tags
+func (us UpdateSchedule) Validate() error {
+	return validate.Check(us)
+}
@@ -0,0 +1,181 @@
+// Package schedule provides access to the recurring task schedules
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jnkroeker/khyme/business/sys/database"
+	"go.uber.org/zap"
+)
+
+// Store manages the set of APIs for schedule access
+type Store struct {
+	log          *zap.SugaredLogger
+	tr           database.Transactor
+	db           sqlx.ExtContext
+	isWithinTran bool
+}
+
+func NewStore(log *zap.SugaredLogger, db *sqlx.DB) Store {
+	return Store{
+		log: log,
+		tr:  db,
+		db:  db,
+	}
+}
+
+// WithinTran runs fn inside a transaction. If the Store is already
+// bound to a transaction, fn joins it instead of starting a new one.
+func (s Store) WithinTran(ctx context.Context, fn func(sqlx.ExtContext) error) error {
+	if s.isWithinTran {
+		return fn(s.db)
+	}
+	return database.WithinTran(ctx, s.log, s.tr, fn)
+}
+
+// Tran returns a copy of the Store bound to the provided transaction
+func (s Store) Tran(tx sqlx.ExtContext) Store {
+	return Store{
+		log:          s.log,
+		tr:           s.tr,
+		db:           tx,
+		isWithinTran: true,
+	}
+}
+
+func (s Store) Create(ctx context.Context, sch Schedule) error {
+	const q = `INSERT INTO schedules
+						(schedule_id, name, cron, resource, prefix, template, force, enabled, next_run, last_run, date_created, date_updated)
+				VALUES
+						(:schedule_id, :name, :cron, :resource, :prefix, :template, :force, :enabled, :next_run, :last_run, :date_created, :date_updated)`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, sch); err != nil {
+		return fmt.Errorf("inserting schedule: %w", err)
+	}
+
+	return nil
+}
+
+// Update replaces the stored schedule with sch
+func (s Store) Update(ctx context.Context, sch Schedule) error {
+	const q = `UPDATE schedules SET
+					name = :name, cron = :cron, resource = :resource, prefix = :prefix, template = :template,
+					force = :force, enabled = :enabled, next_run = :next_run, last_run = :last_run,
+					date_updated = :date_updated
+				WHERE schedule_id = :schedule_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, sch); err != nil {
+		return fmt.Errorf("updating schedule[%s]: %w", sch.ID, err)
+	}
+
+	return nil
+}
+
+// Delete removes a schedule, returning database.ErrNotFound if there is no such schedule
+func (s Store) Delete(ctx context.Context, scheduleID string) error {
+	data := struct {
+		ScheduleID string `db:"schedule_id"`
+	}{
+		ScheduleID: scheduleID,
+	}
+
+	const q = `DELETE FROM schedules WHERE schedule_id = :schedule_id RETURNING *`
+
+	var sch Schedule
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &sch); err != nil {
+		if err == database.ErrNotFound {
+			return database.ErrNotFound
+		}
+		return fmt.Errorf("deleting schedule[%s]: %w", scheduleID, err)
+	}
+
+	return nil
+}
+
+// Query returns a page of schedules ordered by name
+func (s Store) Query(ctx context.Context, pageNumber int, rowsPerPage int) ([]Schedule, error) {
+	data := struct {
+		Offset      int `db:"offset"`
+		RowsPerPage int `db:"rows_per_page"`
+	}{
+		Offset:      (pageNumber - 1) * rowsPerPage,
+		RowsPerPage: rowsPerPage,
+	}
+
+	const q = `SELECT * FROM schedules ORDER BY name, schedule_id OFFSET :offset ROWS FETCH NEXT :rows_per_page ROWS ONLY`
+
+	var schs []Schedule
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &schs); err != nil {
+		return nil, fmt.Errorf("selecting schedules: %w", err)
+	}
+
+	return schs, nil
+}
+
+// QueryByID gets the specified schedule from the database
+func (s Store) QueryByID(ctx context.Context, scheduleID string) (Schedule, error) {
+	data := struct {
+		ScheduleID string `db:"schedule_id"`
+	}{
+		ScheduleID: scheduleID,
+	}
+
+	const q = `SELECT * FROM schedules WHERE schedule_id = :schedule_id`
+
+	var sch Schedule
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &sch); err != nil {
+		if err == database.ErrNotFound {
+			return Schedule{}, database.ErrNotFound
+		}
+		return Schedule{}, fmt.Errorf("selecting schedule[%s]: %w", scheduleID, err)
+	}
+
+	return sch, nil
+}
+
+// QueryDue returns the enabled schedules whose next run is at or before now
+func (s Store) QueryDue(ctx context.Context, now time.Time) ([]Schedule, error) {
+	data := struct {
+		Now time.Time `db:"now"`
+	}{
+		Now: now,
+	}
+
+	const q = `SELECT * FROM schedules WHERE enabled AND next_run <= :now ORDER BY next_run`
+
+	var schs []Schedule
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &schs); err != nil {
+		return nil, fmt.Errorf("selecting due schedules: %w", err)
+	}
+
+	return schs, nil
+}
+
+// Lock takes the advisory lock for firing a schedule, held until the
+// transaction the Store is bound to ends. It reports false without waiting
+// when another transaction already holds it.
+func (s Store) Lock(ctx context.Context, scheduleID string) (bool, error) {
+	h := fnv.New64a()
+	h.Write([]byte("schedule:" + scheduleID))
+
+	data := struct {
+		Key int64 `db:"key"`
+	}{
+		Key: int64(h.Sum64()),
+	}
+
+	const q = `SELECT pg_try_advisory_xact_lock(:key) AS locked`
+
+	var res struct {
+		Locked bool `db:"locked"`
+	}
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &res); err != nil {
+		return false, fmt.Errorf("locking schedule[%s]: %w", scheduleID, err)
+	}
+
+	return res.Locked, nil
+}
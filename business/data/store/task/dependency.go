@@ -0,0 +1,140 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jnkroeker/khyme/business/sys/database"
+	"github.com/lib/pq"
+)
+
+// Dependency is an edge of the task graph: TaskID may not run until DependsOn has succeeded
+type Dependency struct {
+	TaskID    string `db:"task_id" json:"task_id"`
+	DependsOn string `db:"depends_on" json:"depends_on"`
+}
+
+// AddDependencies records that taskID may not run until every task in dependsOn has succeeded
+func (s Store) AddDependencies(ctx context.Context, taskID string, dependsOn []string) error {
+	const q = `INSERT INTO task_dependencies (task_id, depends_on) VALUES (:task_id, :depends_on) ON CONFLICT DO NOTHING`
+
+	for _, parent := range dependsOn {
+		dep := Dependency{
+			TaskID:    taskID,
+			DependsOn: parent,
+		}
+		if err := database.NamedExecContext(ctx, s.log, s.db, q, dep); err != nil {
+			return fmt.Errorf("inserting dependency %s -> %s: %w", taskID, parent, err)
+		}
+	}
+
+	return nil
+}
+
+// QueryByIDs gets the specified tasks from the database. Ids with no task are left out.
+func (s Store) QueryByIDs(ctx context.Context, taskIDs []string) ([]Task, error) {
+	data := struct {
+		TaskIDs interface{} `db:"task_ids"`
+	}{
+		TaskIDs: pq.Array(taskIDs),
+	}
+
+	const q = `SELECT * FROM tasks WHERE task_id = ANY(CAST(:task_ids AS UUID[])) AND deleted_at IS NULL ORDER BY date_created, task_id`
+
+	var tasks []Task
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &tasks); err != nil {
+		return nil, fmt.Errorf("selecting tasks by id: %w", err)
+	}
+
+	return tasks, nil
+}
+
+// LockByIDs gets the specified tasks like QueryByIDs, holding a share lock
+// on them until the transaction ends so they cannot change status or be
+// deleted while tasks that depend on them are stored.
+func (s Store) LockByIDs(ctx context.Context, taskIDs []string) ([]Task, error) {
+	data := struct {
+		TaskIDs interface{} `db:"task_ids"`
+	}{
+		TaskIDs: pq.Array(taskIDs),
+	}
+
+	const q = `SELECT * FROM tasks WHERE task_id = ANY(CAST(:task_ids AS UUID[])) AND deleted_at IS NULL ORDER BY date_created, task_id FOR SHARE`
+
+	var tasks []Task
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &tasks); err != nil {
+		return nil, fmt.Errorf("locking tasks by id: %w", err)
+	}
+
+	return tasks, nil
+}
+
+// QueryGraph returns every dependency edge connected to the task, directly
+// or through other tasks, in either direction.
+func (s Store) QueryGraph(ctx context.Context, taskID string) ([]Dependency, error) {
+	data := struct {
+		TaskID string `db:"task_id"`
+	}{
+		TaskID: taskID,
+	}
+
+	const q = `WITH RECURSIVE component (task_id) AS (
+					SELECT CAST(:task_id AS UUID)
+					UNION
+					SELECT CASE WHEN d.task_id = c.task_id THEN d.depends_on ELSE d.task_id END
+					FROM task_dependencies AS d
+					JOIN component AS c ON d.task_id = c.task_id OR d.depends_on = c.task_id
+				)
+				SELECT d.task_id, d.depends_on
+				FROM task_dependencies AS d
+				JOIN tasks AS c ON c.task_id = d.task_id AND c.deleted_at IS NULL
+				JOIN tasks AS p ON p.task_id = d.depends_on AND p.deleted_at IS NULL
+				WHERE d.task_id IN (SELECT task_id FROM component)
+				ORDER BY d.depends_on, d.task_id`
+
+	var deps []Dependency
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &deps); err != nil {
+		return nil, fmt.Errorf("selecting task graph: %w", err)
+	}
+
+	return deps, nil
+}
+
+// CancelDependents cancels every pending task that depends, directly or
+// through other tasks, on taskID, recording reason as its last error.
+// Tasks already past pending are left alone.
+func (s Store) CancelDependents(ctx context.Context, taskID string, reason string, now time.Time) ([]Task, error) {
+	data := struct {
+		TaskID    string    `db:"task_id"`
+		Reason    string    `db:"reason"`
+		Now       time.Time `db:"now"`
+		Pending   Status    `db:"pending"`
+		Cancelled Status    `db:"cancelled"`
+	}{
+		TaskID:    taskID,
+		Reason:    reason,
+		Now:       now,
+		Pending:   StatusPending,
+		Cancelled: StatusCancelled,
+	}
+
+	const q = `WITH RECURSIVE dependents (task_id) AS (
+					SELECT task_id FROM task_dependencies WHERE depends_on = :task_id
+					UNION
+					SELECT d.task_id
+					FROM task_dependencies AS d
+					JOIN dependents AS p ON d.depends_on = p.task_id
+				)
+				UPDATE tasks SET
+					status = :cancelled, finished_at = :now, last_error = :reason, not_before = NULL
+				WHERE task_id IN (SELECT task_id FROM dependents) AND status = :pending AND deleted_at IS NULL
+				RETURNING *`
+
+	var tasks []Task
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &tasks); err != nil {
+		return nil, fmt.Errorf("cancelling dependents of %s: %w", taskID, err)
+	}
+
+	return tasks, nil
+}
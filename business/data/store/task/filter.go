@@ -0,0 +1,239 @@
+package task
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jnkroeker/khyme/business/sys/validate"
+	"github.com/lib/pq"
+)
+
+// Set of errors returned when a task listing cannot be built
+var (
+	ErrInvalidOrder  = errors.New("tasks cannot be ordered by that field")
+	ErrInvalidCursor = errors.New("cursor is not valid for this listing")
+)
+
+// QueryFilter holds the conditions a task listing is narrowed by.
+// Fields left at their zero value are not applied.
+//
+// Scheduled picks out, or leaves out, the pending tasks held back until a
+// time still to come.
+type QueryFilter struct {
+	Status         []Status
+	Template       string
+	Hooks          string
+	ExecutionImage string
+	Version        string
+	Labels         Labels
+	InputPrefix    string
+	CreatedAfter   *time.Time
+	CreatedBefore  *time.Time
+	Scheduled      *bool
+}
+
+// Set of fields a task listing can be ordered by
+const (
+	OrderByDateCreated = "date_created"
+	OrderByPriority    = "priority"
+)
+
+// OrderBy is the field a task listing is sorted on. Ties are broken
+// on task_id so every row has a stable place to resume from.
+type OrderBy struct {
+	Field string
+	Desc  bool
+}
+
+// DefaultOrderBy lists the newest tasks first
+var DefaultOrderBy = OrderBy{Field: OrderByDateCreated, Desc: true}
+
+// ParseOrderBy builds an OrderBy from a field name and a direction of "asc" or "desc".
+// Empty values fall back to DefaultOrderBy.
+func ParseOrderBy(field string, direction string) (OrderBy, error) {
+	ob := DefaultOrderBy
+
+	switch field {
+	case "":
+	case OrderByDateCreated, OrderByPriority:
+		ob.Field = field
+	default:
+		return OrderBy{}, ErrInvalidOrder
+	}
+
+	switch strings.ToLower(direction) {
+	case "":
+	case "asc":
+		ob.Desc = false
+	case "desc":
+		ob.Desc = true
+	default:
+		return OrderBy{}, ErrInvalidOrder
+	}
+
+	return ob, nil
+}
+
+// Cursor marks the last task of a page so the next page starts after it.
+// It is handed to callers as an opaque string.
+type Cursor struct {
+	Field string `json:"f"`
+	Desc  bool   `json:"d"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// NewCursor returns the cursor that resumes a listing ordered by ob after t
+func NewCursor(t Task, ob OrderBy) Cursor {
+	c := Cursor{
+		Field: ob.Field,
+		Desc:  ob.Desc,
+		ID:    t.ID,
+	}
+
+	switch ob.Field {
+	case OrderByPriority:
+		c.Value = strconv.Itoa(t.Priority)
+	default:
+		c.Value = t.DateCreated.Format(time.RFC3339Nano)
+	}
+
+	return c
+}
+
+// Encode returns the opaque form of the cursor
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseCursor decodes an opaque cursor and checks it belongs to a listing ordered by ob
+func ParseCursor(s string, ob OrderBy) (Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	if c.Field != ob.Field || c.Desc != ob.Desc {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	if err := validate.CheckID(c.ID); err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	if _, err := c.value(); err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	return c, nil
+}
+
+// value converts the cursor value back to the type of the column it came from
+func (c Cursor) value() (interface{}, error) {
+	switch c.Field {
+	case OrderByPriority:
+		return strconv.Atoi(c.Value)
+	default:
+		return time.Parse(time.RFC3339Nano, c.Value)
+	}
+}
+
+// =============================================================================
+
+// listQuery builds the statement and named arguments for a page of tasks
+func listQuery(filter QueryFilter, ob OrderBy, cursor *Cursor, limit int, now time.Time) (string, map[string]interface{}, error) {
+	data := map[string]interface{}{
+		"limit": limit,
+	}
+	// tasks in the trash are never listed
+	where := []string{"deleted_at IS NULL"}
+
+	if len(filter.Status) > 0 {
+		statuses := make([]string, len(filter.Status))
+		for i, st := range filter.Status {
+			statuses[i] = string(st)
+		}
+		data["status"] = pq.Array(statuses)
+		where = append(where, "status = ANY(:status)")
+	}
+	if filter.Template != "" {
+		data["template"] = filter.Template
+		where = append(where, "template = :template")
+	}
+	if filter.Hooks != "" {
+		data["hooks"] = filter.Hooks
+		where = append(where, "hooks = :hooks")
+	}
+	if filter.ExecutionImage != "" {
+		data["exec_image"] = filter.ExecutionImage
+		where = append(where, "exec_image = :exec_image")
+	}
+	if filter.Version != "" {
+		data["version"] = filter.Version
+		where = append(where, "version = :version")
+	}
+	if len(filter.Labels) > 0 {
+		data["labels"] = filter.Labels
+		where = append(where, "labels @> CAST(:labels AS JSONB)")
+	}
+	if filter.InputPrefix != "" {
+		data["input_prefix"] = likePrefix(filter.InputPrefix)
+		where = append(where, "input_url LIKE :input_prefix")
+	}
+	if filter.CreatedAfter != nil {
+		data["created_after"] = *filter.CreatedAfter
+		where = append(where, "date_created >= :created_after")
+	}
+	if filter.CreatedBefore != nil {
+		data["created_before"] = *filter.CreatedBefore
+		where = append(where, "date_created < :created_before")
+	}
+	if filter.Scheduled != nil {
+		data["now"] = now
+		data["pending"] = StatusPending
+		scheduled := "(status = :pending AND not_before IS NOT NULL AND not_before > :now)"
+		if !*filter.Scheduled {
+			scheduled = "NOT " + scheduled
+		}
+		where = append(where, scheduled)
+	}
+
+	dir, cmp := "ASC", ">"
+	if ob.Desc {
+		dir, cmp = "DESC", "<"
+	}
+
+	if cursor != nil {
+		v, err := cursor.value()
+		if err != nil {
+			return "", nil, ErrInvalidCursor
+		}
+		data["cursor_value"] = v
+		data["cursor_id"] = cursor.ID
+		where = append(where, fmt.Sprintf("(%s, task_id) %s (:cursor_value, :cursor_id)", ob.Field, cmp))
+	}
+
+	var b strings.Builder
+	b.WriteString("SELECT * FROM tasks WHERE ")
+	b.WriteString(strings.Join(where, " AND "))
+	fmt.Fprintf(&b, " ORDER BY %s %s, task_id %s LIMIT :limit", ob.Field, dir, dir)
+
+	return b.String(), data, nil
+}
+
+// likePrefix escapes the LIKE wildcards in prefix and matches anything after it
+func likePrefix(prefix string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(prefix) + "%"
+}
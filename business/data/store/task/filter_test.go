@@ -0,0 +1,106 @@
+package task_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jnkroeker/khyme/business/data/store/task"
+)
+
+func TestParseOrderBy(t *testing.T) {
+	tests := []struct {
+		name      string
+		field     string
+		direction string
+		want      task.OrderBy
+		err       error
+	}{
+		{"defaults", "", "", task.DefaultOrderBy, nil},
+		{"priority", "priority", "", task.OrderBy{Field: task.OrderByPriority, Desc: true}, nil},
+		{"ascending", "date_created", "ASC", task.OrderBy{Field: task.OrderByDateCreated}, nil},
+		{"descending", "priority", "desc", task.OrderBy{Field: task.OrderByPriority, Desc: true}, nil},
+		{"unknown field", "status", "", task.OrderBy{}, task.ErrInvalidOrder},
+		{"unknown direction", "", "up", task.OrderBy{}, task.ErrInvalidOrder},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := task.ParseOrderBy(tt.field, tt.direction)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("ParseOrderBy returned %v, want %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Fatalf("ParseOrderBy = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	created := time.Date(2026, 5, 1, 10, 15, 30, 123456789, time.UTC)
+	tk := task.Task{
+		ID:          "5cf37266-3473-4006-984f-9325122678b7",
+		Priority:    -3,
+		DateCreated: created,
+	}
+
+	tests := []struct {
+		name  string
+		ob    task.OrderBy
+		value string
+	}{
+		{"newest first", task.DefaultOrderBy, "2026-05-01T10:15:30.123456789Z"},
+		{"oldest first", task.OrderBy{Field: task.OrderByDateCreated}, "2026-05-01T10:15:30.123456789Z"},
+		{"priority", task.OrderBy{Field: task.OrderByPriority, Desc: true}, "-3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := task.NewCursor(tk, tt.ob)
+
+			got, err := task.ParseCursor(c.Encode(), tt.ob)
+			if err != nil {
+				t.Fatalf("ParseCursor returned %v", err)
+			}
+			if got != c {
+				t.Fatalf("ParseCursor = %+v, want %+v", got, c)
+			}
+			if got.Value != tt.value || got.ID != tk.ID {
+				t.Fatalf("cursor holds %q %q, want %q %q", got.Value, got.ID, tt.value, tk.ID)
+			}
+		})
+	}
+}
+
+func TestParseCursorInvalid(t *testing.T) {
+	encode := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+
+	valid := task.NewCursor(task.Task{ID: "5cf37266-3473-4006-984f-9325122678b7", DateCreated: time.Now()}, task.DefaultOrderBy).Encode()
+
+	tests := []struct {
+		name   string
+		cursor string
+		ob     task.OrderBy
+	}{
+		{"not base64", "!!!", task.DefaultOrderBy},
+		{"not json", encode("cursor"), task.DefaultOrderBy},
+		{"other field", valid, task.OrderBy{Field: task.OrderByPriority, Desc: true}},
+		{"other direction", valid, task.OrderBy{Field: task.OrderByDateCreated}},
+		{"id not a uuid", encode(`{"f":"date_created","d":true,"v":"2026-05-01T10:15:30Z","id":"1; DROP TABLE tasks"}`), task.DefaultOrderBy},
+		{"bad time", encode(`{"f":"date_created","d":true,"v":"yesterday","id":"5cf37266-3473-4006-984f-9325122678b7"}`), task.DefaultOrderBy},
+		{"bad priority", encode(`{"f":"priority","d":true,"v":"high","id":"5cf37266-3473-4006-984f-9325122678b7"}`),
+			task.OrderBy{Field: task.OrderByPriority, Desc: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := task.ParseCursor(tt.cursor, tt.ob); !errors.Is(err, task.ErrInvalidCursor) {
+				t.Fatalf("ParseCursor returned %v, want ErrInvalidCursor", err)
+			}
+		})
+	}
+}
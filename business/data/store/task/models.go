@@ -1,19 +1,46 @@
 package task
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"time"
+
+	"github.com/jnkroeker/khyme/business/sys/validate"
 )
 
 // Task represents a data processing task to be executed
 type Task struct {
-	ID             string        `db:"task_id" json:"id"`
-	DateCreated    time.Time     `db:"date_created" json:"date_created"`
-	Version        string        `db:"version" json:"version"`
-	InputResource  string        `db:"input_url" json:"input_url"`
-	OutputResource string        `db:"output_url" json:"output_url"`
-	Hooks          string        `db:"hooks" json:"hooks"`
-	ExecutionImage string        `db:"exec_image" json:"exec_image"`
-	Timeout        time.Duration `db:"timeout" json:"timeout"`
+	ID                string        `db:"task_id" json:"id"`
+	DateCreated       time.Time     `db:"date_created" json:"date_created"`
+	Version           string        `db:"version" json:"version"`
+	InputResource     string        `db:"input_url" json:"input_url"`
+	OutputResource    string        `db:"output_url" json:"output_url"`
+	Hooks             string        `db:"hooks" json:"hooks"`
+	ExecutionImage    string        `db:"exec_image" json:"exec_image"`
+	Timeout           time.Duration `db:"timeout" json:"timeout"`
+	Template          string        `db:"template" json:"template"`
+	MatchReason       string        `db:"match_reason" json:"match_reason"`
+	GroupID           *string       `db:"group_id" json:"group_id,omitempty"`
+	DedupKey          *string       `db:"dedup_key" json:"dedup_key,omitempty"`
+	Priority          int           `db:"priority" json:"priority"`
+	Labels            Labels        `db:"labels" json:"labels"`
+	CallbackURL       *string       `db:"callback_url" json:"callback_url,omitempty"`
+	Parameters        Parameters    `db:"parameters" json:"parameters"`
+	Status            Status        `db:"status" json:"status"`
+	StartedAt         *time.Time    `db:"started_at" json:"started_at,omitempty"`
+	FinishedAt        *time.Time    `db:"finished_at" json:"finished_at,omitempty"`
+	Attempt           int           `db:"attempt" json:"attempt"`
+	WorkerID          *string       `db:"worker_id" json:"worker_id,omitempty"`
+	ClaimedAt         *time.Time    `db:"claimed_at" json:"claimed_at,omitempty"`
+	LeaseExpiresAt    *time.Time    `db:"lease_expires_at" json:"lease_expires_at,omitempty"`
+	LastError         *string       `db:"last_error" json:"last_error,omitempty"`
+	NotBefore         *time.Time    `db:"not_before" json:"not_before,omitempty"`
+	CancelRequestedAt *time.Time    `db:"cancel_requested_at" json:"cancel_requested_at,omitempty"`
+	DeletedAt         *time.Time    `db:"deleted_at" json:"deleted_at,omitempty"`
+	Progress          *Progress     `db:"progress" json:"progress,omitempty"`
+	ETA               *time.Time    `db:"-" json:"eta,omitempty"`
+	RetryPolicy       `json:"retry"`
 }
 
 // NewTask contains information needed to create a new Task
@@ -29,4 +56,267 @@ type NewTask struct {
 	Hooks          string        `db:"hooks" json:"hooks"`
 	ExecutionImage string        `db:"exec_image" json:"exec_image"`
 	Timeout        time.Duration `db:"timeout" json:"timeout"`
+	Template       string        `db:"template" json:"template"`
+	MatchReason    string        `db:"match_reason" json:"match_reason"`
+	GroupID        *string       `db:"group_id" json:"group_id,omitempty"`
+	DedupKey       *string       `db:"dedup_key" json:"dedup_key,omitempty"`
+	Priority       int           `db:"priority" json:"priority"`
+	Labels         Labels        `db:"labels" json:"labels"`
+	CallbackURL    *string       `db:"callback_url" json:"callback_url,omitempty"`
+	Parameters     Parameters    `db:"parameters" json:"parameters"`
+	NotBefore      *time.Time    `db:"not_before" json:"not_before,omitempty"`
+	RetryPolicy    `json:"retry"`
+}
+
+// RetryPolicy controls when a failed Task is run again. Fields left nil
+// are filled in from the queue defaults when the policy is applied, so a
+// field set to zero, such as no jitter, keeps its zero.
+type RetryPolicy struct {
+	MaxAttempts *int           `db:"max_attempts" json:"max_attempts,omitempty" validate:"omitempty,gte=1"`
+	Base        *time.Duration `db:"backoff_base" json:"backoff_base,omitempty" validate:"omitempty,gte=0"`
+	Max         *time.Duration `db:"backoff_max" json:"backoff_max,omitempty" validate:"omitempty,gte=0"`
+	Jitter      *float64       `db:"backoff_jitter" json:"backoff_jitter,omitempty" validate:"omitempty,gte=0,lte=1"`
+}
+
+// RetryRequest is the retry policy a submission sets in place of its
+// template's. Backoffs are durations such as "30s". A field left out keeps
+// the template's setting.
+type RetryRequest struct {
+	MaxAttempts *int     `json:"max_attempts,omitempty" validate:"omitempty,gte=1"`
+	Base        string   `json:"backoff_base,omitempty"`
+	Max         string   `json:"backoff_max,omitempty"`
+	Jitter      *float64 `json:"backoff_jitter,omitempty" validate:"omitempty,gte=0,lte=1"`
+}
+
+// NewTaskRequest contains what a caller submits to create a Task.
+// Template names a template to use instead of matching on the input url.
+// Priority, when given, replaces the template default.
+// Force creates the tasks even when the same resource was already submitted.
+// NotBefore, or a Delay such as "15m" from now, holds the tasks back until then.
+// DependsOn lists the ids of tasks that must succeed before these may run.
+type NewTaskRequest struct {
+	InputResource string        `json:"input_url" validate:"required,url"`
+	Template      string        `json:"template,omitempty"`
+	Priority      *int          `json:"priority,omitempty" validate:"omitempty,gte=0,lte=100"`
+	Labels        Labels        `json:"labels,omitempty" validate:"omitempty,max=32,dive,keys,required,max=63,endkeys,max=255"`
+	CallbackURL   string        `json:"callback_url,omitempty" validate:"omitempty,url"`
+	Parameters    Parameters    `json:"parameters,omitempty"`
+	Retry         *RetryRequest `json:"retry,omitempty"`
+	Force         bool          `json:"force,omitempty"`
+	NotBefore     *time.Time    `json:"not_before,omitempty" validate:"omitempty,excluded_with=Delay"`
+	Delay         string        `json:"delay,omitempty"`
+	DependsOn     []string      `json:"depends_on,omitempty" validate:"omitempty,max=100,dive,required"`
+}
+
+// Validate checks the request against its validate tags
+func (ntr NewTaskRequest) Validate() error {
+	return validate.Check(ntr)
+}
+
+// NewDAG is a set of submissions created together whose tasks depend on
+// one another. The depends_on of each node may name the key of another
+// node, standing for every task that node creates, or the id of a task
+// that already exists.
+type NewDAG struct {
+	Nodes []DAGNode `json:"nodes" validate:"required,min=1,max=100,dive"`
+}
+
+// DAGNode is one submission of a NewDAG, named by a key unique within it
+type DAGNode struct {
+	Key  string         `json:"key" validate:"required"`
+	Task NewTaskRequest `json:"task" validate:"required"`
+}
+
+// Validate checks the request against its validate tags
+func (nd NewDAG) Validate() error {
+	return validate.Check(nd)
+}
+
+// DAG reports the tasks created for each node of a NewDAG
+type DAG struct {
+	Keys  map[string][]string `json:"keys"`
+	Tasks []Task              `json:"tasks"`
+}
+
+// Graph is the dependency graph a task belongs to: the tasks linked to it
+// through dependencies in either direction and the edges between them.
+type Graph struct {
+	TaskID string       `json:"task_id"`
+	Nodes  []GraphNode  `json:"nodes"`
+	Edges  []Dependency `json:"edges"`
+}
+
+// GraphNode is a task of a Graph
+type GraphNode struct {
+	ID            string `json:"id"`
+	Status        Status `json:"status"`
+	Template      string `json:"template"`
+	InputResource string `json:"input_url"`
+}
+
+// Group reports on the set of tasks a fan-out template produced from one resource
+type Group struct {
+	ID        string         `json:"group_id"`
+	Total     int            `json:"total"`
+	Counts    map[Status]int `json:"counts"`
+	Complete  bool           `json:"complete"`
+	Succeeded bool           `json:"succeeded"`
+	Tasks     []Task         `json:"tasks"`
+}
+
+// Page is one page of a task listing. NextCursor is empty on the last page.
+type Page struct {
+	Tasks      []Task `json:"tasks"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// UpdateStatus contains the status a Task is being moved to
+type UpdateStatus struct {
+	Status   string      `json:"status" validate:"required"`
+	WorkerID string      `json:"worker_id"`
+	Results  []NewResult `json:"results,omitempty" validate:"omitempty,max=1000,dive"`
+}
+
+// Validate checks the request against its validate tags
+func (us UpdateStatus) Validate() error {
+	return validate.Check(us)
+}
+
+// AddResults contains a manifest a worker sends apart from the status
+// update that made its Task succeed
+type AddResults struct {
+	WorkerID string      `json:"worker_id" validate:"required"`
+	Results  []NewResult `json:"results" validate:"required,min=1,max=1000,dive"`
+}
+
+// Validate checks the request against its validate tags
+func (ar AddResults) Validate() error {
+	return validate.Check(ar)
+}
+
+// UpdatePriority contains the priority an operator is moving a Task to
+type UpdatePriority struct {
+	Priority *int `json:"priority" validate:"required,gte=0,lte=100"`
+}
+
+// Validate checks the request against its validate tags
+func (up UpdatePriority) Validate() error {
+	return validate.Check(up)
+}
+
+// =============================================================================
+
+// Labels are caller supplied key/value tags kept with a Task
+type Labels map[string]string
+
+// Value implements the driver.Valuer interface, storing Labels as json
+func (l Labels) Value() (driver.Value, error) {
+	if l == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(l)
+}
+
+// Scan implements the sql.Scanner interface
+func (l *Labels) Scan(src interface{}) error {
+	return scanJSON(src, l)
+}
+
+// Progress is the latest progress a worker reported on a running Task
+type Progress struct {
+	Percent     float64   `json:"percent"`
+	Stage       string    `json:"stage,omitempty"`
+	Bytes       int64     `json:"bytes,omitempty"`
+	Message     string    `json:"message,omitempty"`
+	DateUpdated time.Time `json:"date_updated"`
+}
+
+// Value implements the driver.Valuer interface, storing Progress as json
+func (p Progress) Value() (driver.Value, error) {
+	return json.Marshal(p)
+}
+
+// Scan implements the sql.Scanner interface
+func (p *Progress) Scan(src interface{}) error {
+	return scanJSON(src, p)
+}
+
+// ProgressReport is one entry in the progress history of a Task
+type ProgressReport struct {
+	ID          string    `db:"progress_id" json:"id"`
+	TaskID      string    `db:"task_id" json:"task_id"`
+	WorkerID    string    `db:"worker_id" json:"worker_id"`
+	Attempt     int       `db:"attempt" json:"attempt"`
+	Percent     float64   `db:"percent" json:"percent"`
+	Stage       string    `db:"stage" json:"stage,omitempty"`
+	Bytes       int64     `db:"bytes" json:"bytes,omitempty"`
+	Message     string    `db:"message" json:"message,omitempty"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+}
+
+// Result is one object a Task produced under its output url
+type Result struct {
+	ID          string    `db:"result_id" json:"id"`
+	TaskID      string    `db:"task_id" json:"task_id"`
+	URL         string    `db:"url" json:"url"`
+	Size        int64     `db:"size" json:"size"`
+	Checksum    string    `db:"checksum" json:"checksum"`
+	ContentType string    `db:"content_type" json:"content_type"`
+	Metadata    Metadata  `db:"metadata" json:"metadata"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+}
+
+// NewResult contains what a worker reports about an object its Task produced
+type NewResult struct {
+	URL         string   `json:"url" validate:"required,url"`
+	Size        int64    `json:"size" validate:"gte=0"`
+	Checksum    string   `json:"checksum" validate:"required,max=255"`
+	ContentType string   `json:"content_type" validate:"required,max=255"`
+	Metadata    Metadata `json:"metadata,omitempty"`
+}
+
+// Metadata describes a produced object, such as its duration or codec
+type Metadata map[string]interface{}
+
+// Value implements the driver.Valuer interface, storing Metadata as json
+func (m Metadata) Value() (driver.Value, error) {
+	if m == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(m)
+}
+
+// Scan implements the sql.Scanner interface
+func (m *Metadata) Scan(src interface{}) error {
+	return scanJSON(src, m)
+}
+
+// Parameters are caller supplied settings handed through to the worker
+type Parameters map[string]interface{}
+
+// Value implements the driver.Valuer interface, storing Parameters as json
+func (p Parameters) Value() (driver.Value, error) {
+	if p == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(p)
+}
+
+// Scan implements the sql.Scanner interface
+func (p *Parameters) Scan(src interface{}) error {
+	return scanJSON(src, p)
+}
+
+// scanJSON decodes a json column into dest
+func scanJSON(src interface{}, dest interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, dest)
+	case string:
+		return json.Unmarshal([]byte(v), dest)
+	default:
+		return fmt.Errorf("cannot scan %T into json", src)
+	}
 }
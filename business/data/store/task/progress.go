@@ -0,0 +1,40 @@
+package task
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jnkroeker/khyme/business/sys/database"
+)
+
+// AddProgress appends a report to the progress history of its task
+func (s Store) AddProgress(ctx context.Context, pr ProgressReport) error {
+	const q = `INSERT INTO task_progress
+						(progress_id, task_id, worker_id, attempt, percent, stage, bytes, message, date_created)
+				VALUES
+						(:progress_id, :task_id, :worker_id, :attempt, :percent, :stage, :bytes, :message, :date_created)`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, pr); err != nil {
+		return fmt.Errorf("inserting progress: %w", err)
+	}
+
+	return nil
+}
+
+// QueryProgress returns the progress history of a task, oldest first
+func (s Store) QueryProgress(ctx context.Context, taskID string) ([]ProgressReport, error) {
+	data := struct {
+		TaskID string `db:"task_id"`
+	}{
+		TaskID: taskID,
+	}
+
+	const q = `SELECT * FROM task_progress WHERE task_id = :task_id ORDER BY date_created, progress_id`
+
+	var reports []ProgressReport
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &reports); err != nil {
+		return nil, fmt.Errorf("selecting progress of task[%s]: %w", taskID, err)
+	}
+
+	return reports, nil
+}
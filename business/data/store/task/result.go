@@ -0,0 +1,44 @@
+package task
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jnkroeker/khyme/business/sys/database"
+)
+
+// AddResults records the objects a task produced. An object already
+// recorded for the task is left as it is, so a manifest may be resent.
+func (s Store) AddResults(ctx context.Context, results []Result) error {
+	const q = `INSERT INTO task_results
+						(result_id, task_id, url, size, checksum, content_type, metadata, date_created)
+				VALUES
+						(:result_id, :task_id, :url, :size, :checksum, :content_type, :metadata, :date_created)
+				ON CONFLICT (task_id, url) DO NOTHING`
+
+	for _, r := range results {
+		if err := database.NamedExecContext(ctx, s.log, s.db, q, r); err != nil {
+			return fmt.Errorf("inserting result %s: %w", r.URL, err)
+		}
+	}
+
+	return nil
+}
+
+// QueryResults returns the objects a task produced, ordered by url
+func (s Store) QueryResults(ctx context.Context, taskID string) ([]Result, error) {
+	data := struct {
+		TaskID string `db:"task_id"`
+	}{
+		TaskID: taskID,
+	}
+
+	const q = `SELECT * FROM task_results WHERE task_id = :task_id ORDER BY url`
+
+	var results []Result
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &results); err != nil {
+		return nil, fmt.Errorf("selecting results of task[%s]: %w", taskID, err)
+	}
+
+	return results, nil
+}
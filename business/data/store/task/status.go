@@ -0,0 +1,79 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Set of errors returned when a Task cannot make a move
+var (
+	ErrInvalidStatus  = errors.New("status is not a valid task status")
+	ErrNotLeaseHolder = errors.New("worker_id does not hold the lease on the task")
+)
+
+// Status is the point a Task has reached in its lifecycle
+type Status string
+
+// Set of statuses a Task moves through
+const (
+	StatusPending   Status = "pending"
+	StatusClaimed   Status = "claimed"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+// transitions lists, for every status, the statuses a Task may move to next.
+// A status missing from the map can never be left. Tasks only become
+// claimed through the queue, which leases them to a worker as it does.
+var transitions = map[Status][]Status{
+	StatusPending: {StatusCancelled},
+	StatusClaimed: {StatusRunning, StatusPending, StatusFailed, StatusCancelled},
+	StatusRunning: {StatusSucceeded, StatusFailed, StatusPending, StatusCancelled},
+	StatusFailed:  {StatusPending},
+}
+
+// ParseStatus converts a string into a Status, rejecting unknown values
+func ParseStatus(s string) (Status, error) {
+	switch st := Status(s); st {
+	case StatusPending, StatusClaimed, StatusRunning, StatusSucceeded, StatusFailed, StatusCancelled:
+		return st, nil
+	}
+	return "", ErrInvalidStatus
+}
+
+// CanTransition reports whether a Task in status from may move to status to
+func CanTransition(from Status, to Status) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTerminal reports whether s ends a run of the Task.
+// A failed Task is terminal but may still be put back to pending.
+func (s Status) IsTerminal() bool {
+	switch s {
+	case StatusSucceeded, StatusFailed, StatusCancelled:
+		return true
+	}
+	return false
+}
+
+// TransitionError is returned when a Task is asked to make a move
+// the lifecycle does not allow
+type TransitionError struct {
+	From Status
+	To   Status
+}
+
+// pointer semantics for plain error interface return
+func (err *TransitionError) Error() string {
+	return fmt.Sprintf("task cannot move from %s to %s", err.From, err.To)
+}
+
+// Conflict implements the validate.ConflictError interface
+func (err *TransitionError) Conflict() {}
@@ -13,17 +13,39 @@ import (
 
 // Store manages the set of APIs for Task access
 type Store struct {
-	log *zap.SugaredLogger
-	db  sqlx.ExtContext
+	log          *zap.SugaredLogger
+	tr           database.Transactor
+	db           sqlx.ExtContext
+	isWithinTran bool
 }
 
 func NewStore(log *zap.SugaredLogger, db *sqlx.DB) Store {
 	return Store{
 		log: log,
+		tr:  db,
 		db:  db,
 	}
 }
 
+// WithinTran runs fn inside a transaction. If the Store is already
+// bound to a transaction, fn joins it instead of starting a new one.
+func (s Store) WithinTran(ctx context.Context, fn func(sqlx.ExtContext) error) error {
+	if s.isWithinTran {
+		return fn(s.db)
+	}
+	return database.WithinTran(ctx, s.log, s.tr, fn)
+}
+
+// Tran returns a copy of the Store bound to the provided transaction
+func (s Store) Tran(tx sqlx.ExtContext) Store {
+	return Store{
+		log:          s.log,
+		tr:           s.tr,
+		db:           tx,
+		isWithinTran: true,
+	}
+}
+
 func (s Store) Create(ctx context.Context, nt NewTask, now time.Time) (Task, error) {
 	task := Task{
 		ID:             validate.GenerateID(),
@@ -34,12 +56,27 @@ func (s Store) Create(ctx context.Context, nt NewTask, now time.Time) (Task, err
 		Hooks:          nt.Hooks,
 		ExecutionImage: nt.ExecutionImage,
 		Timeout:        nt.Timeout,
+		Template:       nt.Template,
+		MatchReason:    nt.MatchReason,
+		GroupID:        nt.GroupID,
+		DedupKey:       nt.DedupKey,
+		Priority:       nt.Priority,
+		Labels:         nt.Labels,
+		CallbackURL:    nt.CallbackURL,
+		Parameters:     nt.Parameters,
+		NotBefore:      nt.NotBefore,
+		Status:         StatusPending,
+		RetryPolicy:    nt.RetryPolicy,
 	}
 
 	const q = `INSERT INTO tasks
-						(task_id, date_created, version, input_url, output_url, hooks, exec_image, timeout)
+						(task_id, date_created, version, input_url, output_url, hooks, exec_image, timeout, template, match_reason, group_id, dedup_key,
+						priority, labels, callback_url, parameters, not_before, status, attempt,
+						max_attempts, backoff_base, backoff_max, backoff_jitter)
 				VALUES
-						(:task_id, :date_created, :version, :input_url, :output_url, :hooks, :exec_image, :timeout)`
+						(:task_id, :date_created, :version, :input_url, :output_url, :hooks, :exec_image, :timeout, :template, :match_reason, :group_id, :dedup_key,
+						:priority, :labels, :callback_url, :parameters, :not_before, :status, :attempt,
+						:max_attempts, :backoff_base, :backoff_max, :backoff_jitter)`
 
 	if err := database.NamedExecContext(ctx, s.log, s.db, q, task); err != nil {
 		return Task{}, fmt.Errorf("inserting task: %w", err)
@@ -48,23 +85,55 @@ func (s Store) Create(ctx context.Context, nt NewTask, now time.Time) (Task, err
 	return task, nil
 }
 
-func (s Store) Delete(ctx context.Context, taskID string) error {
+// Delete moves a task to the trash, returning database.ErrNotFound if there
+// is no such task outside it. A task in the trash is left out of every
+// query but QueryTrash until it is restored or purged.
+func (s Store) Delete(ctx context.Context, taskID string, now time.Time) error {
 	data := struct {
-		TaskID string `db:"task_id"`
+		TaskID string    `db:"task_id"`
+		Now    time.Time `db:"now"`
 	}{
 		TaskID: taskID,
+		Now:    now,
 	}
 
-	const q = `DELETE FROM tasks WHERE task_id = :task_id`
+	const q = `UPDATE tasks SET deleted_at = :now WHERE task_id = :task_id AND deleted_at IS NULL RETURNING *`
 
-	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+	var task Task
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &task); err != nil {
+		if err == database.ErrNotFound {
+			return database.ErrNotFound
+		}
 		return fmt.Errorf("deleting task: %w", err)
 	}
 
 	return nil
 }
 
-func (s Store) Query(ctx context.Context, pageNumber int, rowsPerPage int) ([]Task, error) {
+// Restore takes a task back out of the trash, returning database.ErrNotFound
+// if there is no such task in it
+func (s Store) Restore(ctx context.Context, taskID string) (Task, error) {
+	data := struct {
+		TaskID string `db:"task_id"`
+	}{
+		TaskID: taskID,
+	}
+
+	const q = `UPDATE tasks SET deleted_at = NULL WHERE task_id = :task_id AND deleted_at IS NOT NULL RETURNING *`
+
+	var task Task
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &task); err != nil {
+		if err == database.ErrNotFound {
+			return Task{}, database.ErrNotFound
+		}
+		return Task{}, fmt.Errorf("restoring task: %w", err)
+	}
+
+	return task, nil
+}
+
+// QueryTrash returns a page of the tasks in the trash, most recently deleted first
+func (s Store) QueryTrash(ctx context.Context, pageNumber int, rowsPerPage int) ([]Task, error) {
 	data := struct {
 		Offset      int `db:"offset"`
 		RowsPerPage int `db:"rows_per_page"`
@@ -73,7 +142,42 @@ func (s Store) Query(ctx context.Context, pageNumber int, rowsPerPage int) ([]Ta
 		RowsPerPage: rowsPerPage,
 	}
 
-	const q = `SELECT * FROM tasks ORDER BY task_id OFFSET :offset ROWS FETCH NEXT :rows_per_page ROWS ONLY`
+	const q = `SELECT * FROM tasks WHERE deleted_at IS NOT NULL
+				ORDER BY deleted_at DESC, task_id OFFSET :offset ROWS FETCH NEXT :rows_per_page ROWS ONLY`
+
+	var tasks []Task
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &tasks); err != nil {
+		return nil, fmt.Errorf("selecting trash: %w", err)
+	}
+
+	return tasks, nil
+}
+
+// Purge removes the tasks deleted before deletedBefore for good, returning how many
+func (s Store) Purge(ctx context.Context, deletedBefore time.Time) (int, error) {
+	data := struct {
+		DeletedBefore time.Time `db:"deleted_before"`
+	}{
+		DeletedBefore: deletedBefore,
+	}
+
+	const q = `DELETE FROM tasks WHERE deleted_at < :deleted_before RETURNING *`
+
+	var tasks []Task
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &tasks); err != nil {
+		return 0, fmt.Errorf("purging trash: %w", err)
+	}
+
+	return len(tasks), nil
+}
+
+// Query returns up to limit tasks matching filter in the order given by ob.
+// When cursor is set the listing resumes after the task it marks.
+func (s Store) Query(ctx context.Context, filter QueryFilter, ob OrderBy, cursor *Cursor, limit int, now time.Time) ([]Task, error) {
+	q, data, err := listQuery(filter, ob, cursor, limit, now)
+	if err != nil {
+		return nil, err
+	}
 
 	var tasks []Task
 	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &tasks); err != nil {
@@ -85,3 +189,294 @@ func (s Store) Query(ctx context.Context, pageNumber int, rowsPerPage int) ([]Ta
 
 	return tasks, nil
 }
+
+// QueryByID gets the specified task from the database
+func (s Store) QueryByID(ctx context.Context, taskID string) (Task, error) {
+	data := struct {
+		TaskID string `db:"task_id"`
+	}{
+		TaskID: taskID,
+	}
+
+	const q = `SELECT * FROM tasks WHERE task_id = :task_id AND deleted_at IS NULL`
+
+	var task Task
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &task); err != nil {
+		if err == database.ErrNotFound {
+			return Task{}, database.ErrNotFound
+		}
+		return Task{}, fmt.Errorf("selecting task[%s]: %w", taskID, err)
+	}
+
+	return task, nil
+}
+
+// LockByID gets the specified task like QueryByID, holding a row lock on it
+// until the transaction ends so nothing else can move it meanwhile
+func (s Store) LockByID(ctx context.Context, taskID string) (Task, error) {
+	data := struct {
+		TaskID string `db:"task_id"`
+	}{
+		TaskID: taskID,
+	}
+
+	const q = `SELECT * FROM tasks WHERE task_id = :task_id AND deleted_at IS NULL FOR UPDATE`
+
+	var task Task
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &task); err != nil {
+		if err == database.ErrNotFound {
+			return Task{}, database.ErrNotFound
+		}
+		return Task{}, fmt.Errorf("locking task[%s]: %w", taskID, err)
+	}
+
+	return task, nil
+}
+
+// RequestCancel marks a claimed or running task to be cancelled by its
+// worker, which learns of it on its next heartbeat. It returns
+// database.ErrNotFound when no such task is held by a worker.
+func (s Store) RequestCancel(ctx context.Context, taskID string, now time.Time) (Task, error) {
+	data := struct {
+		TaskID  string    `db:"task_id"`
+		Now     time.Time `db:"now"`
+		Claimed Status    `db:"claimed"`
+		Running Status    `db:"running"`
+	}{
+		TaskID:  taskID,
+		Now:     now,
+		Claimed: StatusClaimed,
+		Running: StatusRunning,
+	}
+
+	const q = `UPDATE tasks SET cancel_requested_at = COALESCE(cancel_requested_at, :now)
+				WHERE task_id = :task_id AND status IN (:claimed, :running) AND deleted_at IS NULL
+				RETURNING *`
+
+	var task Task
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &task); err != nil {
+		if err == database.ErrNotFound {
+			return Task{}, database.ErrNotFound
+		}
+		return Task{}, fmt.Errorf("requesting cancel: %w", err)
+	}
+
+	return task, nil
+}
+
+// Abort cancels a task that has not finished, whichever worker holds it,
+// recording reason as its last error. It returns database.ErrNotFound if
+// there is no such unfinished task.
+func (s Store) Abort(ctx context.Context, taskID string, reason string, now time.Time) (Task, error) {
+	data := struct {
+		TaskID    string    `db:"task_id"`
+		Reason    string    `db:"reason"`
+		Now       time.Time `db:"now"`
+		Pending   Status    `db:"pending"`
+		Claimed   Status    `db:"claimed"`
+		Running   Status    `db:"running"`
+		Cancelled Status    `db:"cancelled"`
+	}{
+		TaskID:    taskID,
+		Reason:    reason,
+		Now:       now,
+		Pending:   StatusPending,
+		Claimed:   StatusClaimed,
+		Running:   StatusRunning,
+		Cancelled: StatusCancelled,
+	}
+
+	const q = `UPDATE tasks SET
+					status = :cancelled, finished_at = :now, last_error = :reason,
+					lease_expires_at = NULL, not_before = NULL
+				WHERE task_id = :task_id AND status IN (:pending, :claimed, :running) AND deleted_at IS NULL
+				RETURNING *`
+
+	var task Task
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &task); err != nil {
+		if err == database.ErrNotFound {
+			return Task{}, database.ErrNotFound
+		}
+		return Task{}, fmt.Errorf("aborting task: %w", err)
+	}
+
+	return task, nil
+}
+
+// RunNow clears the time a pending task is held back until, so the next
+// claim can take it. It returns database.ErrNotFound if there is no such
+// pending task.
+func (s Store) RunNow(ctx context.Context, taskID string) (Task, error) {
+	data := struct {
+		TaskID  string `db:"task_id"`
+		Pending Status `db:"pending"`
+	}{
+		TaskID:  taskID,
+		Pending: StatusPending,
+	}
+
+	const q = `UPDATE tasks SET not_before = NULL WHERE task_id = :task_id AND status = :pending AND deleted_at IS NULL RETURNING *`
+
+	var task Task
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &task); err != nil {
+		if err == database.ErrNotFound {
+			return Task{}, database.ErrNotFound
+		}
+		return Task{}, fmt.Errorf("running task now: %w", err)
+	}
+
+	return task, nil
+}
+
+// UpdatePriority sets the priority of a task, returning database.ErrNotFound
+// if there is no such task
+func (s Store) UpdatePriority(ctx context.Context, taskID string, priority int) (Task, error) {
+	data := struct {
+		TaskID   string `db:"task_id"`
+		Priority int    `db:"priority"`
+	}{
+		TaskID:   taskID,
+		Priority: priority,
+	}
+
+	const q = `UPDATE tasks SET priority = :priority WHERE task_id = :task_id AND deleted_at IS NULL RETURNING *`
+
+	var task Task
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &task); err != nil {
+		if err == database.ErrNotFound {
+			return Task{}, database.ErrNotFound
+		}
+		return Task{}, fmt.Errorf("updating task priority: %w", err)
+	}
+
+	return task, nil
+}
+
+// QueryByDedupKey gets the live or succeeded task holding the dedup key.
+// Failed and cancelled tasks give up their key.
+func (s Store) QueryByDedupKey(ctx context.Context, dedupKey string) (Task, error) {
+	data := struct {
+		DedupKey  string `db:"dedup_key"`
+		Failed    Status `db:"failed"`
+		Cancelled Status `db:"cancelled"`
+	}{
+		DedupKey:  dedupKey,
+		Failed:    StatusFailed,
+		Cancelled: StatusCancelled,
+	}
+
+	const q = `SELECT * FROM tasks WHERE dedup_key = :dedup_key AND status NOT IN (:failed, :cancelled) AND deleted_at IS NULL`
+
+	var task Task
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &task); err != nil {
+		if err == database.ErrNotFound {
+			return Task{}, database.ErrNotFound
+		}
+		return Task{}, fmt.Errorf("selecting task by dedup key: %w", err)
+	}
+
+	return task, nil
+}
+
+// QueryGroup returns every task in a group, returning database.ErrNotFound
+// when the group has none.
+func (s Store) QueryGroup(ctx context.Context, groupID string) ([]Task, error) {
+	data := struct {
+		GroupID string `db:"group_id"`
+	}{
+		GroupID: groupID,
+	}
+
+	const q = `SELECT * FROM tasks WHERE group_id = :group_id AND deleted_at IS NULL ORDER BY template`
+
+	var tasks []Task
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &tasks); err != nil {
+		return nil, fmt.Errorf("selecting group: %w", err)
+	}
+	if len(tasks) == 0 {
+		return nil, database.ErrNotFound
+	}
+
+	return tasks, nil
+}
+
+// UpdateStatus moves a Task to a new status, also returning the status it
+// moved from. The row is locked while the move is checked against the
+// lifecycle so concurrent updates cannot both succeed. A claimed or running
+// task is only moved on for workerID, the worker holding its lease.
+func (s Store) UpdateStatus(ctx context.Context, taskID string, to Status, workerID string, now time.Time) (Task, Status, error) {
+	var task Task
+	var from Status
+
+	tran := func(tx sqlx.ExtContext) error {
+		data := struct {
+			TaskID string `db:"task_id"`
+		}{
+			TaskID: taskID,
+		}
+
+		const sel = `SELECT * FROM tasks WHERE task_id = :task_id AND deleted_at IS NULL FOR UPDATE`
+
+		if err := database.NamedQueryStruct(ctx, s.log, tx, sel, data, &task); err != nil {
+			if err == database.ErrNotFound {
+				return database.ErrNotFound
+			}
+			return fmt.Errorf("selecting task: %w", err)
+		}
+
+		from = task.Status
+		if !CanTransition(task.Status, to) {
+			return &TransitionError{From: task.Status, To: to}
+		}
+
+		// a worker whose lease was reaped must not report on a task another has claimed since
+		if (from == StatusClaimed || from == StatusRunning) && (task.WorkerID == nil || *task.WorkerID != workerID) {
+			return ErrNotLeaseHolder
+		}
+
+		// the update is fenced on the holder too, not only on the lock
+		holder := task.WorkerID
+
+		task.Status = to
+		switch {
+		case to == StatusPending:
+			task.StartedAt = nil
+			task.FinishedAt = nil
+			task.WorkerID = nil
+			task.ClaimedAt = nil
+			task.LeaseExpiresAt = nil
+			task.NotBefore = nil
+			task.CancelRequestedAt = nil
+		case to == StatusRunning:
+			task.StartedAt = &now
+		case to.IsTerminal():
+			task.FinishedAt = &now
+			task.LeaseExpiresAt = nil
+		}
+
+		const upd = `UPDATE tasks SET
+						status = :status, started_at = :started_at, finished_at = :finished_at, attempt = :attempt,
+						worker_id = :worker_id, claimed_at = :claimed_at, lease_expires_at = :lease_expires_at,
+						not_before = :not_before, cancel_requested_at = :cancel_requested_at
+					WHERE task_id = :task_id AND worker_id IS NOT DISTINCT FROM :holder`
+
+		args := struct {
+			Task
+			Holder *string `db:"holder"`
+		}{
+			Task:   task,
+			Holder: holder,
+		}
+		if err := database.NamedExecContext(ctx, s.log, tx, upd, args); err != nil {
+			return fmt.Errorf("updating task status: %w", err)
+		}
+
+		return nil
+	}
+
+	if err := s.WithinTran(ctx, tran); err != nil {
+		return Task{}, "", err
+	}
+
+	return task, from, nil
+}
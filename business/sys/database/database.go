@@ -3,6 +3,7 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"net/url"
@@ -12,7 +13,7 @@ import (
 
 	"github.com/jmoiron/sqlx"
 	"github.com/jnkroeker/khyme/foundation/web"
-	_ "github.com/lib/pq" // Calls this database driver's init function
+	"github.com/lib/pq" // Calls this database driver's init function
 	"go.uber.org/zap"
 )
 
@@ -22,8 +23,12 @@ var (
 	ErrInvalidID             = errors.New("ID is not in its proper form")
 	ErrAuthenticationFailure = errors.New("authentication failed")
 	ErrForbidden             = errors.New("attempted action is not allowed")
+	ErrDBDuplicatedEntry     = errors.New("duplicated entry")
 )
 
+// uniqueViolation is the postgres error code for a unique constraint violation
+const uniqueViolation = "23505"
+
 // Config is the required properties to use the database
 type Config struct {
 	User         string
@@ -93,12 +98,50 @@ func StatusCheck(ctx context.Context, db *sqlx.DB) error {
 	return db.QueryRowContext(ctx, q).Scan(&tmp)
 }
 
+// Transactor interface needed to begin transaction.
+type Transactor interface {
+	BeginTxx(ctx context.Context, opts *sql.TxOptions) (*sqlx.Tx, error)
+}
+
+// WithinTran runs the passed function inside a transaction, committing
+// when it returns nil and rolling back otherwise.
+func WithinTran(ctx context.Context, log *zap.SugaredLogger, db Transactor, fn func(sqlx.ExtContext) error) error {
+	traceID := web.GetTraceId(ctx)
+
+	log.Infow("begin tran", "traceid", traceID)
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tran: %w", err)
+	}
+
+	// Rollback is a no-op once the transaction has been committed
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Errorw("rollback tran", "traceid", traceID, "ERROR", err)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	log.Infow("commit tran", "traceid", traceID)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tran: %w", err)
+	}
+
+	return nil
+}
+
 // NamedExecContext is a helper function to execute a CRUD operation
 func NamedExecContext(ctx context.Context, log *zap.SugaredLogger, db sqlx.ExtContext, query string, data interface{}) error {
 	q := queryString(query, data)
 	log.Infow("database.NamedExecContext", "traceid", web.GetTraceId(ctx), "query", q)
 
 	if _, err := sqlx.NamedExecContext(ctx, db, query, data); err != nil {
+		if pqerr, ok := err.(*pq.Error); ok && pqerr.Code == uniqueViolation {
+			return ErrDBDuplicatedEntry
+		}
 		return err
 	}
 
@@ -119,8 +162,12 @@ func NamedQuerySlice(ctx context.Context, log *zap.SugaredLogger, db sqlx.ExtCon
 
 	rows, err := sqlx.NamedQueryContext(ctx, db, query, data)
 	if err != nil {
+		if pqerr, ok := err.(*pq.Error); ok && pqerr.Code == uniqueViolation {
+			return ErrDBDuplicatedEntry
+		}
 		return err
 	}
+	defer rows.Close()
 
 	// Construct an instance of the type specified by the slice
 	// from each row and add it to the slice.
@@ -133,6 +180,38 @@ func NamedQuerySlice(ctx context.Context, log *zap.SugaredLogger, db sqlx.ExtCon
 		slice.Set(reflect.Append(slice, v.Elem()))
 	}
 
+	return rows.Err()
+}
+
+// NamedQueryStruct is a helper for queries that return a single row to be unmarshalled into a struct.
+// It returns ErrNotFound when the query matches no rows.
+func NamedQueryStruct(ctx context.Context, log *zap.SugaredLogger, db sqlx.ExtContext, query string, data interface{}, dest interface{}) error {
+	q := queryString(query, data)
+	log.Infow("database.NamedQueryStruct", "traceid", web.GetTraceId(ctx), "query", q)
+
+	rows, err := sqlx.NamedQueryContext(ctx, db, query, data)
+	if err != nil {
+		if pqerr, ok := err.(*pq.Error); ok && pqerr.Code == uniqueViolation {
+			return ErrDBDuplicatedEntry
+		}
+		return err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			if pqerr, ok := err.(*pq.Error); ok && pqerr.Code == uniqueViolation {
+				return ErrDBDuplicatedEntry
+			}
+			return err
+		}
+		return ErrNotFound
+	}
+
+	if err := rows.StructScan(dest); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -29,6 +29,14 @@ func (err *RequestError) Error() string {
 	return err.Err.Error()
 }
 
+// ConflictError is implemented by errors reporting a request that clashes
+// with the current state of what it acts on, so they can be answered with
+// a 409 without the caller knowing where they came from.
+type ConflictError interface {
+	error
+	Conflict()
+}
+
 type FieldError struct {
 	Field string `json:"field"`
 	Err   string `json:"error"`
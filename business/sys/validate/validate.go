@@ -1,6 +1,10 @@
 package validate
 
 import (
+	"fmt"
+	"reflect"
+	"strings"
+
 	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
 )
@@ -9,8 +13,80 @@ var validate *validator.Validate
 
 func init() {
 	validate = validator.New()
+
+	// report fields by the names callers use in their json documents
+	validate.RegisterTagNameFunc(func(fld reflect.StructField) string {
+		name := strings.SplitN(fld.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+}
+
+// Check validates the provided struct against its validate tags,
+// returning FieldErrors describing every field that failed.
+func Check(val interface{}) error {
+	if err := validate.Struct(val); err != nil {
+		verrors, ok := err.(validator.ValidationErrors)
+		if !ok {
+			return err
+		}
+
+		var fields FieldErrors
+		for _, verror := range verrors {
+			field := FieldError{
+				Field: fieldName(verror),
+				Err:   message(verror),
+			}
+			fields = append(fields, field)
+		}
+
+		return fields
+	}
+
+	return nil
 }
 
 func GenerateID() string {
 	return uuid.NewString()
 }
+
+// CheckID validates that the format of an ID is valid
+func CheckID(id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+	return nil
+}
+
+// fieldName returns the dotted path to the field, without the struct name
+func fieldName(fe validator.FieldError) string {
+	ns := fe.Namespace()
+	if i := strings.Index(ns, "."); i >= 0 {
+		return ns[i+1:]
+	}
+	return ns
+}
+
+// message describes a failed validation in words a caller can act on
+func message(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "url":
+		return "must be a valid url"
+	case "uuid", "uuid4":
+		return "must be a valid id"
+	case "min", "gte":
+		return fmt.Sprintf("must be at least %s", fe.Param())
+	case "max", "lte":
+		return fmt.Sprintf("must be at most %s", fe.Param())
+	case "excluded_with":
+		return fmt.Sprintf("cannot be set together with %s", strings.ToLower(fe.Param()))
+	case "oneof":
+		return fmt.Sprintf("must be one of %s", fe.Param())
+	default:
+		return fmt.Sprintf("failed the %q check", fe.Tag())
+	}
+}
@@ -38,6 +38,12 @@ func Errors(log *zap.SugaredLogger) web.Middleware {
 						Error: act.Error(),
 					}
 					status = act.Status
+				// request clashes with the current state, such as an illegal lifecycle move
+				case validate.ConflictError:
+					er = validate.ErrorResponse{
+						Error: act.Error(),
+					}
+					status = http.StatusConflict
 				default:
 					// untrusted error, return 500
 					er = validate.ErrorResponse{
@@ -0,0 +1,105 @@
+package cron_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jnkroeker/khyme/foundation/cron"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		ok   bool
+	}{
+		{"every minute", "* * * * *", true},
+		{"macro", "@hourly", true},
+		{"macro any case", "@Daily", true},
+		{"names", "0 9 * jan-mar mon-fri", true},
+		{"sunday as seven", "0 0 * * 7", true},
+		{"lists and steps", "0,30 */2 1-15/7 * *", true},
+		{"too few fields", "* * * *", false},
+		{"too many fields", "* * * * * *", false},
+		{"out of range", "60 * * * *", false},
+		{"backwards range", "* 5-1 * * *", false},
+		{"zero step", "*/0 * * * *", false},
+		{"bad name", "* * * foo *", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := cron.Parse(tt.expr)
+			if tt.ok && err != nil {
+				t.Fatalf("Parse(%q) returned %v", tt.expr, err)
+			}
+			if !tt.ok && !errors.Is(err, cron.ErrInvalidExpression) {
+				t.Fatalf("Parse(%q) returned %v, want ErrInvalidExpression", tt.expr, err)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skipf("loading time zone: %v", err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("loading time zone: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"every minute", "* * * * *",
+			time.Date(2026, 5, 1, 10, 15, 30, 0, time.UTC),
+			time.Date(2026, 5, 1, 10, 16, 0, 0, time.UTC)},
+		{"strictly after", "15 10 * * *",
+			time.Date(2026, 5, 1, 10, 15, 0, 0, time.UTC),
+			time.Date(2026, 5, 2, 10, 15, 0, 0, time.UTC)},
+		{"later hour", "0 9 * * *",
+			time.Date(2026, 5, 1, 6, 10, 0, 0, time.UTC),
+			time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC)},
+		{"half hour offset", "0 9 * * *",
+			time.Date(2026, 5, 1, 6, 10, 0, 0, kolkata),
+			time.Date(2026, 5, 1, 9, 0, 0, 0, kolkata)},
+		{"day of week", "0 0 * * mon",
+			time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2026, 5, 4, 0, 0, 0, 0, time.UTC)},
+		{"either day field", "0 0 13 * fri",
+			time.Date(2026, 5, 9, 0, 0, 0, 0, time.UTC),
+			time.Date(2026, 5, 13, 0, 0, 0, 0, time.UTC)},
+		{"end of year", "0 0 1 1 *",
+			time.Date(2026, 12, 31, 23, 59, 0, 0, time.UTC),
+			time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"leap day", "0 0 29 2 *",
+			time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"never fires", "0 0 30 2 *",
+			time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			time.Time{}},
+		{"across clocks going back", "0 * * * *",
+			time.Date(2026, 11, 1, 0, 30, 0, 0, newYork),
+			time.Date(2026, 11, 1, 1, 0, 0, 0, newYork)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := cron.Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q) returned %v", tt.expr, err)
+			}
+
+			got := s.Next(tt.from)
+			if !got.Equal(tt.want) {
+				t.Fatalf("Next(%s) = %s, want %s", tt.from, got, tt.want)
+			}
+		})
+	}
+}
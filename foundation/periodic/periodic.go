@@ -0,0 +1,50 @@
+// Package periodic runs a function on a fixed interval in a goroutine
+// that can be stopped, and waited on, during shutdown.
+package periodic
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Runner owns the goroutine calling the periodic function
+type Runner struct {
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// Start calls fn every interval until Stop is called. The context handed to
+// fn is cancelled by Stop so work in flight can abandon early.
+func Start(interval time.Duration, fn func(ctx context.Context)) *Runner {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r := Runner{
+		cancel: cancel,
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				fn(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return &r
+}
+
+// Stop signals the goroutine to finish and blocks until it has
+func (r *Runner) Stop() {
+	r.cancel()
+	r.wg.Wait()
+}
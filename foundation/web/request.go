@@ -13,10 +13,16 @@ func Param(r *http.Request, key string) string {
 	return m[key]
 }
 
+// validator is implemented by request documents that can check themselves.
+// Keeping this an interface lets the business layer own the validation rules.
+type validator interface {
+	Validate() error
+}
+
 // Decode reads the body of an HTTP request for a JSON document.
 // The body is decoded into the provided value
 //
-// If the provided value is a struct then it is checked for validation tags.
+// If the provided value is a validator then it is checked once decoded.
 func Decode(r *http.Request, val interface{}) error {
 	decoder := json.NewDecoder(r.Body)
 	decoder.DisallowUnknownFields()
@@ -24,5 +30,11 @@ func Decode(r *http.Request, val interface{}) error {
 		return err
 	}
 
+	if v, ok := val.(validator); ok {
+		if err := v.Validate(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }